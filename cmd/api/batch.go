@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/validator"
+)
+
+const (
+	batchModeAtomic     = "atomic"
+	batchModeBestEffort = "best_effort"
+	maxBatchOperations  = 100
+)
+
+// fields of a movie in a batch operation, for updates only the provided fields are changed
+type batchMovieInput struct {
+	Title   *string       `json:"title"`
+	Year    *int32        `json:"year"`
+	Runtime *data.Runtime `json:"runtime"`
+	Genres  []string      `json:"genres"`
+	Status  *string       `json:"status"`
+}
+
+func (input *batchMovieInput) apply(movie *data.Movie) {
+	if input == nil {
+		return
+	}
+
+	if input.Title != nil {
+		movie.Title = *input.Title
+	}
+
+	if input.Year != nil {
+		movie.Year = *input.Year
+	}
+
+	if input.Runtime != nil {
+		movie.Runtime = *input.Runtime
+	}
+
+	if input.Genres != nil {
+		movie.Genres = input.Genres
+	}
+
+	if input.Status != nil {
+		movie.Status = *input.Status
+	}
+}
+
+type batchOperation struct {
+	Op      string           `json:"op"`
+	ID      int64            `json:"id"`
+	Version int32            `json:"version"` // required for updates, deletes check it when given
+	Movie   *batchMovieInput `json:"movie"`
+}
+
+type batchResult struct {
+	Index  int         `json:"index"`
+	Op     string      `json:"op"`
+	Status int         `json:"status"`
+	Movie  *data.Movie `json:"movie,omitempty"`
+	Error  any         `json:"error,omitempty"`
+}
+
+// runs a single operation of the batch, the returned result describes success as well as failure
+func (app *application) runBatchOperation(batch *data.MovieBatch, genres *data.GenreTaxonomy, i int, op batchOperation) (batchResult, error) {
+	result := batchResult{Index: i, Op: op.Op}
+	v := validator.New()
+
+	switch op.Op {
+	case "create":
+		movie := &data.Movie{}
+		op.Movie.apply(movie)
+
+		if data.ValidateMovie(v, movie, genres); !v.Valid() {
+			result.Status, result.Error = http.StatusUnprocessableEntity, v.Errors
+			return result, nil
+		}
+
+		err := batch.Insert(movie)
+
+		if err != nil {
+			return result, err
+		}
+
+		result.Status, result.Movie = http.StatusCreated, movie
+
+	case "update":
+		v.Check(op.Version > 0, "version", "must be provided")
+
+		if !v.Valid() {
+			result.Status, result.Error = http.StatusUnprocessableEntity, v.Errors
+			return result, nil
+		}
+
+		movie, err := batch.Get(op.ID)
+
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				result.Status, result.Error = http.StatusNotFound, "the requested resource could not be found"
+				return result, nil
+			default:
+				return result, err
+			}
+		}
+
+		op.Movie.apply(movie)
+		movie.Version = op.Version
+
+		if data.ValidateMovie(v, movie, genres); !v.Valid() {
+			result.Status, result.Error = http.StatusUnprocessableEntity, v.Errors
+			return result, nil
+		}
+
+		err = batch.Update(movie)
+
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				result.Status, result.Error = http.StatusConflict, "unable to update the record due to an edit conflict, please try again"
+				return result, nil
+			default:
+				return result, err
+			}
+		}
+
+		result.Status, result.Movie = http.StatusOK, movie
+
+	case "delete":
+		err := batch.Delete(op.ID, op.Version)
+
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				result.Status, result.Error = http.StatusNotFound, "the requested resource could not be found"
+				return result, nil
+			case errors.Is(err, data.ErrEditConflict):
+				result.Status, result.Error = http.StatusConflict, "unable to update the record due to an edit conflict, please try again"
+				return result, nil
+			default:
+				return result, err
+			}
+		}
+
+		result.Status = http.StatusOK
+
+	default:
+		v.AddError("op", "must be one of create, update or delete")
+		result.Status, result.Error = http.StatusUnprocessableEntity, v.Errors
+	}
+
+	return result, nil
+}
+
+func (app *application) batchMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Mode       string           `json:"mode"`
+		Operations []batchOperation `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Mode == "" {
+		input.Mode = batchModeAtomic
+	}
+
+	v := validator.New()
+
+	v.Check(validator.PermittedValue(input.Mode, batchModeAtomic, batchModeBestEffort), "mode", "must be atomic or best_effort")
+	v.Check(len(input.Operations) >= 1, "operations", "must contain at least 1 operation")
+	v.Check(len(input.Operations) <= maxBatchOperations, "operations", fmt.Sprintf("must not contain more than %d operations", maxBatchOperations))
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	genres, err := app.models.Genres.Taxonomy()
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	batch, err := app.models.Movies.BeginBatch(app.contextGetUser(r).ID)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	defer batch.Rollback()
+
+	results := make([]batchResult, 0, len(input.Operations))
+	failed := -1
+
+	for i, op := range input.Operations {
+		err = batch.Savepoint()
+
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		result, err := app.runBatchOperation(batch, genres, i, op)
+
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		results = append(results, result)
+
+		if result.Error == nil {
+			err = batch.ReleaseSavepoint()
+		} else {
+			err = batch.RollbackToSavepoint()
+
+			if failed < 0 {
+				failed = i
+			}
+		}
+
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if failed >= 0 && input.Mode == batchModeAtomic {
+			break
+		}
+	}
+
+	// nothing is saved in atomic mode, so operations before the failure are reported as rolled back
+	// and the ones after it as not attempted
+	if failed >= 0 && input.Mode == batchModeAtomic {
+		for i := range results[:failed] {
+			results[i].Status = http.StatusFailedDependency
+			results[i].Movie = nil
+			results[i].Error = fmt.Sprintf("rolled back because operation %d failed", failed)
+		}
+
+		for i := failed + 1; i < len(input.Operations); i++ {
+			results = append(results, batchResult{
+				Index:  i,
+				Op:     input.Operations[i].Op,
+				Status: http.StatusFailedDependency,
+				Error:  fmt.Sprintf("not attempted because operation %d failed", failed),
+			})
+		}
+
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, envelope{"results": results})
+		return
+	}
+
+	err = batch.Commit()
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, result := range results {
+		if result.Movie != nil {
+			app.formatMovies(r, result.Movie)
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"results": results}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+
+	"movies.samkha.net/internal/validator"
+)
+
+// changes of the catalog after the since sequence in the order they were made
+// the returned cursor is the since value of the next request, has_more tells whether it would return changes already
+func (app *application) listMovieChangesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Since int64
+		Limit int
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Since = int64(app.readInt(qs, "since", 0, v))
+	input.Limit = app.readInt(qs, "limit", 100, v)
+
+	v.Check(input.Since >= 0, "since", "must not be negative")
+	v.Check(input.Limit >= 1 && input.Limit <= 1000, "limit", "must be between 1 and 1000")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//one more change than asked for tells whether there are more
+	changes, err := app.models.Changes.GetSince(input.Since, input.Limit+1)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	hasMore := len(changes) > input.Limit
+
+	if hasMore {
+		changes = changes[:input.Limit]
+	}
+
+	cursor := input.Since
+
+	if len(changes) > 0 {
+		cursor = changes[len(changes)-1].Sequence
+	}
+
+	for _, change := range changes {
+		if change.Movie != nil {
+			change.Movie.RuntimeFormat = app.contextGetRuntimeFormat(r)
+		}
+	}
+
+	env := envelope{"changes": changes, "cursor": cursor, "has_more": hasMore}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
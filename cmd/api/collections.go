@@ -0,0 +1,442 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/validator"
+)
+
+// fetches the collection addressed by :id, writing the error response if it can't
+// collections which aren't visible to the user are reported as not found, owned ones must belong to the user
+func (app *application) collectionFromRequest(w http.ResponseWriter, r *http.Request, owned bool) (*data.Collection, bool) {
+	id, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	collection, err := app.models.Collections.Get(id)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return nil, false
+	}
+
+	user := app.contextGetUser(r)
+
+	if !collection.VisibleTo(user) {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	if owned && collection.UserID != user.ID {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	return collection, true
+}
+
+// public collections, or with mine=true all collections of the current user
+func (app *application) listCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Mine bool
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Mine = app.readBool(qs, "mine", false, v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-updated_at")
+	input.Filters.SortSafeList = []string{"id", "title", "updated_at", "-id", "-title", "-updated_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var ownerID int64
+
+	if input.Mine {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		ownerID = user.ID
+	}
+
+	collections, metadata, err := app.models.Collections.GetAll(ownerID, input.Filters)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collections": collections, "metadata": metadata}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Public      bool   `json:"public"`
+	}
+
+	err := app.readJSON(w, r, &input)
+
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	collection := &data.Collection{
+		UserID:      app.contextGetUser(r).ID,
+		Title:       input.Title,
+		Description: input.Description,
+		Public:      input.Public,
+	}
+
+	v := validator.New()
+
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Insert(collection)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/collections/%d", collection.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"collection": collection}, headers)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.collectionFromRequest(w, r, false)
+
+	if !ok {
+		return
+	}
+
+	var err error
+
+	collection.Entries, err = app.models.Collections.GetEntries(collection.ID)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movies := make([]*data.Movie, len(collection.Entries))
+
+	for i, entry := range collection.Entries {
+		movies[i] = entry.Movie
+	}
+
+	err = app.annotateMovies(w, r, movies...)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.collectionFromRequest(w, r, true)
+
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		Public      *bool   `json:"public"`
+	}
+
+	err := app.readJSON(w, r, &input)
+
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Title != nil {
+		collection.Title = *input.Title
+	}
+
+	if input.Description != nil {
+		collection.Description = *input.Description
+	}
+
+	if input.Public != nil {
+		collection.Public = *input.Public
+	}
+
+	v := validator.New()
+
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Update(collection)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.collectionFromRequest(w, r, true)
+
+	if !ok {
+		return
+	}
+
+	err := app.models.Collections.Delete(collection.ID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "collection successfully deleted"}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// adds the movie to the end of the collection, or updates its note when it is already there
+func (app *application) putCollectionEntryHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.collectionFromRequest(w, r, true)
+
+	if !ok {
+		return
+	}
+
+	movieID, err := app.readInt64Param(r, "movie_id")
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Note string `json:"note"`
+	}
+
+	if r.ContentLength != 0 {
+		err = app.readJSON(w, r, &input)
+
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	v := validator.New()
+
+	if data.ValidateCollectionNote(v, input.Note); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.UpsertEntry(collection.ID, movieID, input.Note)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	collection.Entries, err = app.models.Collections.GetEntries(collection.ID)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movies := make([]*data.Movie, len(collection.Entries))
+
+	for i, entry := range collection.Entries {
+		movies[i] = entry.Movie
+	}
+
+	err = app.annotateMovies(w, r, movies...)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCollectionEntryHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.collectionFromRequest(w, r, true)
+
+	if !ok {
+		return
+	}
+
+	movieID, err := app.readInt64Param(r, "movie_id")
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Collections.DeleteEntry(collection.ID, movieID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie removed from collection"}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// body lists the ids of every movie of the collection in the new order
+func (app *application) reorderCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.collectionFromRequest(w, r, true)
+
+	if !ok {
+		return
+	}
+
+	var input struct {
+		MovieIDs []int64 `json:"movie_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.MovieIDs != nil, "movie_ids", "must be provided")
+	v.Check(validator.Unique(input.MovieIDs), "movie_ids", "must not contain duplicate values")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Reorder(collection.ID, input.MovieIDs)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEntriesMismatch):
+			v.AddError("movie_ids", "must contain every movie of the collection exactly once")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	collection.Entries, err = app.models.Collections.GetEntries(collection.ID)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movies := make([]*data.Movie, len(collection.Entries))
+
+	for i, entry := range collection.Entries {
+		movies[i] = entry.Movie
+	}
+
+	err = app.annotateMovies(w, r, movies...)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
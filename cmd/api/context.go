@@ -9,7 +9,10 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey          = contextKey("user")
+	runtimeFormatContextKey = contextKey("runtime_format")
+)
 
 // returns copy of request with provided User struct
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
@@ -27,3 +30,16 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+func (app *application) contextSetRuntimeFormat(r *http.Request, format data.RuntimeFormat) *http.Request {
+	ctx := context.WithValue(r.Context(), runtimeFormatContextKey, format)
+
+	return r.WithContext(ctx)
+}
+
+// the default format unless the runtimeFormat middleware stored another one
+func (app *application) contextGetRuntimeFormat(r *http.Request) data.RuntimeFormat {
+	format, _ := r.Context().Value(runtimeFormatContextKey).(data.RuntimeFormat)
+
+	return format
+}
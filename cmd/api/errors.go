@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -45,6 +46,11 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, supported ...string) {
+	message := fmt.Sprintf("the request content type must be one of: %s", strings.Join(supported, ", "))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
@@ -55,6 +61,12 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since you last retrieved it, please fetch it again"
+
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
 func (app *application) ratLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
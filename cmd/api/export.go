@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/validator"
+)
+
+// time allowed to write each batch of an export, replaces the server WriteTimeout
+const exportWriteTimeout = 30 * time.Second
+
+// export formats and their content types
+var exportFormats = map[string]string{
+	"json":   "application/json",
+	"ndjson": "application/x-ndjson",
+	"csv":    "text/csv",
+}
+
+// writes movies of an export one at a time
+type movieExportWriter interface {
+	begin() error
+	write(movie *data.Movie) error
+	flush() error
+	end() error
+}
+
+type jsonExportWriter struct {
+	w     io.Writer
+	enc   *json.Encoder
+	count int
+}
+
+func (jw *jsonExportWriter) begin() error {
+	_, err := io.WriteString(jw.w, `{"movies":[`)
+	return err
+}
+
+func (jw *jsonExportWriter) write(movie *data.Movie) error {
+	if jw.count > 0 {
+		if _, err := io.WriteString(jw.w, ","); err != nil {
+			return err
+		}
+	}
+
+	jw.count++
+
+	return jw.enc.Encode(movie)
+}
+
+func (jw *jsonExportWriter) flush() error { return nil }
+
+func (jw *jsonExportWriter) end() error {
+	_, err := io.WriteString(jw.w, "]}\n")
+	return err
+}
+
+type ndjsonExportWriter struct {
+	enc *json.Encoder
+}
+
+func (nw *ndjsonExportWriter) begin() error { return nil }
+
+func (nw *ndjsonExportWriter) write(movie *data.Movie) error {
+	return nw.enc.Encode(movie)
+}
+
+func (nw *ndjsonExportWriter) flush() error { return nil }
+
+func (nw *ndjsonExportWriter) end() error { return nil }
+
+// columns and genres formatting match what POST /v1/movies/import accepts
+type csvExportWriter struct {
+	cw *csv.Writer
+}
+
+func (cw *csvExportWriter) begin() error {
+	return cw.cw.Write([]string{"id", "title", "year", "runtime", "genres", "status", "external_ids", "version"})
+}
+
+func (cw *csvExportWriter) write(movie *data.Movie) error {
+	return cw.cw.Write([]string{
+		strconv.FormatInt(movie.ID, 10),
+		movie.Title,
+		strconv.FormatInt(int64(movie.Year), 10),
+		movie.Runtime.Text(movie.RuntimeFormat),
+		strings.Join(movie.Genres, ","),
+		movie.Status,
+		movie.ExternalIDs.String(),
+		strconv.FormatInt(int64(movie.Version), 10),
+	})
+}
+
+func (cw *csvExportWriter) flush() error {
+	cw.cw.Flush()
+	return cw.cw.Error()
+}
+
+func (cw *csvExportWriter) end() error {
+	return cw.flush()
+}
+
+func newMovieExportWriter(format string, w io.Writer) movieExportWriter {
+	switch format {
+	case "csv":
+		return &csvExportWriter{cw: csv.NewWriter(w)}
+	case "ndjson":
+		return &ndjsonExportWriter{enc: json.NewEncoder(w)}
+	default:
+		return &jsonExportWriter{w: w, enc: json.NewEncoder(w)}
+	}
+}
+
+// format query parameter wins over the Accept header, json is the default
+func (app *application) negotiateExportFormat(r *http.Request, v *validator.Validator) string {
+	format := app.readString(r.URL.Query(), "format", "")
+
+	if format != "" {
+		v.Check(validator.PermittedValue(format, "json", "ndjson", "csv"), "format", "must be one of json, ndjson or csv")
+		return format
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+
+		if err != nil {
+			continue
+		}
+
+		for format, contentType := range exportFormats {
+			if mediaType == contentType || (format == "ndjson" && mediaType == "application/ndjson") {
+				return format
+			}
+		}
+	}
+
+	return "json"
+}
+
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.MovieSearch
+		Format string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	input.MovieSearch, input.Filters = app.readMovieSearch(r, v)
+	input.Format = app.negotiateExportFormat(r, v)
+
+	v.Check(validator.PermittedValue(input.Filters.Sort, input.Filters.SortSafeList...), "sort", "invalid sort value")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	exporter := newMovieExportWriter(input.Format, w)
+	started := false
+	written := 0
+
+	// headers are only sent once the first batch has been read, so a failing query still gets a proper error response
+	start := func() error {
+		started = true
+		rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+
+		w.Header().Set("Content-Type", exportFormats[input.Format])
+		w.Header().Set("Content-Disposition", "attachment; filename=movies."+input.Format)
+		w.WriteHeader(http.StatusOK)
+
+		return exporter.begin()
+	}
+
+	err := app.models.Movies.Export(r.Context(), input.MovieSearch, input.Filters, func(movie *data.Movie) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		app.formatMovies(r, movie)
+
+		if err := exporter.write(movie); err != nil {
+			return err
+		}
+
+		written++
+
+		// push every batch to the client and keep extending the server WriteTimeout while data flows
+		if written%500 == 0 {
+			if err := exporter.flush(); err != nil {
+				return err
+			}
+
+			rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+
+			return rc.Flush()
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// status has already been sent, all we can do is log and cut the response short
+		app.logError(r, err)
+		return
+	}
+
+	if !started {
+		if err := start(); err != nil {
+			app.logError(r, err)
+			return
+		}
+	}
+
+	if err := exporter.end(); err != nil {
+		app.logError(r, err)
+	}
+}
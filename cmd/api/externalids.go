@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/validator"
+)
+
+// replaces every external id of the movie, an empty object removes them all
+func (app *application) updateMovieExternalIDsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	if !app.preconditionMet(r, versionETag(movie.Version)) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ExternalIDs data.ExternalIDs `json:"external_ids"`
+	}
+
+	err = app.readJSON(w, r, &input)
+
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.ExternalIDs != nil, "external_ids", "must be provided")
+
+	if data.ValidateExternalIDs(v, "external_ids", input.ExternalIDs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ExternalIDs.Replace(movie, input.ExternalIDs, app.contextGetUser(r).ID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_ids", "must not contain an identifier of another movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	movie.ExternalIDs = input.ExternalIDs
+
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(movie.Version))
+
+	app.formatMovies(r, movie)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// groups of movies which may be duplicates of each other, the movie parameter narrows them to the group of one movie
+func (app *application) listDuplicateMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID int64
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.MovieID = int64(app.readInt(qs, "movie", 0, v))
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = "title"
+	input.Filters.SortSafeList = []string{"title"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	groups, metadata, err := app.models.Movies.GetDuplicates(input.MovieID, input.Filters)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"duplicates": groups, "metadata": metadata}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/validator"
+)
+
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.Genres.GetAll()
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"genres": genres}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// the slug is derived from the name, aliases are given in any spelling and stored in slug form
+func (app *application) createGenreHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name    string   `json:"name"`
+		Aliases []string `json:"aliases"`
+	}
+
+	err := app.readJSON(w, r, &input)
+
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	genre := &data.Genre{
+		Slug:    data.GenreSlug(input.Name),
+		Name:    input.Name,
+		Aliases: []string{},
+	}
+
+	for _, alias := range input.Aliases {
+		genre.Aliases = append(genre.Aliases, data.GenreSlug(alias))
+	}
+
+	v := validator.New()
+
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Insert(genre)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("genre", "a genre with this name or alias already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"genre": genre}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,31 +1,53 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"movies.samkha.net/internal/data"
 	"movies.samkha.net/internal/validator"
 )
 
 func (app *application) readIdParam(r *http.Request) (int64, error) {
+	return app.readInt64Param(r, "id")
+}
+
+// reads id like route parameters other than :id, e.g. :review_id
+func (app *application) readInt64Param(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 
 	if err != nil {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
 }
 
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	version, err := strconv.ParseInt(params.ByName("version"), 10, 32)
+
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version parameter")
+	}
+
+	return int32(version), nil
+}
+
 type envelope map[string]any
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
@@ -109,6 +131,112 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	return nil
 }
 
+// strong etag derived from the version of a single record
+func versionETag(version int32) string {
+	return strconv.Quote(strconv.FormatInt(int64(version), 10))
+}
+
+// strong etag derived from hash of the json representation of data
+func hashETag(data any) (string, error) {
+	js, err := json.Marshal(data)
+
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(js)
+
+	return strconv.Quote(hex.EncodeToString(sum[:16])), nil
+}
+
+// strong etag of a representation of a versioned record, its version etag extended by a hash of the representation
+// so it changes with anything the representation holds, like aggregates which don't bump the version
+// it still satisfies If-Match for the version, see preconditionMet
+func representationETag(version int32, data any) (string, error) {
+	hash, err := hashETag(data)
+
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.Quote(fmt.Sprintf("%d-%s", version, strings.Trim(hash, `"`))), nil
+}
+
+// reports whether etag matches any of the comma separated entity tags in the header value
+// weak comparison ignores the W/ prefix, as required for If-None-Match
+// strong comparison never matches weak tags, as required for If-Match
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" {
+			return true
+		}
+
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writes 304 Not Modified response if If-None-Match header matches the etag
+// returns true if response has been written
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+
+	header := r.Header.Get("If-None-Match")
+
+	if header == "" || !etagMatches(header, etag, true) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+
+	return true
+}
+
+// adds the request header to Vary unless it's listed already
+func addVary(w http.ResponseWriter, header string) {
+	if !slices.Contains(w.Header().Values("Vary"), header) {
+		w.Header().Add("Vary", header)
+	}
+}
+
+// checks If-Match header against the current etag of the resource
+// missing header means client doesn't care about concurrent modification
+// representation etags match the version etag they extend, since edits are only guarded by the version
+func (app *application) preconditionMet(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+
+	if header == "" {
+		return true
+	}
+
+	if etagMatches(header, etag, false) {
+		return true
+	}
+
+	prefix := strings.TrimSuffix(etag, `"`) + "-"
+
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.HasPrefix(strings.TrimSpace(candidate), prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // reads string from query string
 func (app *application) readString(qs url.Values, key, defaultValue string) string {
 	s := qs.Get(key)
@@ -131,6 +259,26 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 	return strings.Split(csv, ",")
 }
 
+// reads comma separated ids from query string
+// validator to record error if any value isn't a positive integer
+func (app *application) readIDs(qs url.Values, key string, v *validator.Validator) []int64 {
+	values := app.readCSV(qs, key, []string{})
+	ids := make([]int64, 0, len(values))
+
+	for _, value := range values {
+		id, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+
+		if err != nil || id < 1 {
+			v.AddError(key, "must contain positive integer values only.")
+			return nil
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
 // reads int from query string
 // validator to record error if value can't be converted into int
 func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
@@ -149,6 +297,114 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// reads bool from query string
+// validator to record error if value can't be converted into bool
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+
+	if err != nil {
+		v.AddError(key, "must be a boolean value.")
+		return defaultValue
+	}
+
+	return b
+}
+
+// preferences past this rarely match a translation
+const maxLocales = 10
+
+// reads the locales the client prefers, most preferred first
+// the lang query parameter (comma separated) takes precedence over the Accept-Language header
+// every regional locale is followed by its language, so "de-CH" falls back to "de" before the next preference
+func (app *application) readLocales(r *http.Request) []string {
+	var tags []string
+
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		tags = strings.Split(lang, ",")
+	} else {
+		tags = parseAcceptLanguage(r.Header.Get("Accept-Language"))
+	}
+
+	locales := []string{}
+	seen := make(map[string]bool)
+
+	for _, tag := range tags {
+		locale, ok := data.NormaliseLocale(tag)
+
+		if !ok {
+			continue
+		}
+
+		language, _, _ := strings.Cut(locale, "-")
+
+		for _, l := range []string{locale, language} {
+			if !seen[l] {
+				seen[l] = true
+				locales = append(locales, l)
+			}
+		}
+
+		if len(locales) >= maxLocales {
+			return locales[:maxLocales]
+		}
+	}
+
+	return locales
+}
+
+// language ranges of an Accept-Language header ordered by quality, the wildcard and ranges with q=0 are dropped
+func parseAcceptLanguage(header string) []string {
+	type languageRange struct {
+		tag     string
+		quality float64
+	}
+
+	var ranges []languageRange
+
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.TrimSpace(tag)
+		quality := 1.0
+
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+
+			if key == "q" {
+				q, err := strconv.ParseFloat(value, 64)
+
+				if err != nil {
+					q = 0
+				}
+
+				quality = q
+			}
+		}
+
+		if tag == "" || tag == "*" || quality <= 0 {
+			continue
+		}
+
+		ranges = append(ranges, languageRange{tag, quality})
+	}
+
+	//stable, so ranges of equal quality keep the order the client sent them in
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].quality > ranges[j].quality })
+
+	tags := make([]string, len(ranges))
+
+	for i, r := range ranges {
+		tags[i] = r.tag
+	}
+
+	return tags
+}
+
 // helper to run background functions
 func (app *application) background(fn func()) {
 	//increment waitGroup counter for each background goroutine
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/validator"
+)
+
+const (
+	maxImportBytes = 32 << 20
+	maxImportRows  = 50_000
+)
+
+// validation errors of a single row, row numbers start at 1 and exclude the csv header
+type importRowError struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	dryRun := app.readBool(r.URL.Query(), "dry_run", false, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var parse func(io.Reader, *data.GenreTaxonomy) ([]*data.Movie, []importRowError, error)
+
+	switch mediaType {
+	case "text/csv":
+		parse = parseMovieCSV
+	case "application/x-ndjson", "application/ndjson":
+		parse = parseMovieNDJSON
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "text/csv", "application/x-ndjson")
+		return
+	}
+
+	genres, err := app.models.Genres.Taxonomy()
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	movies, rowErrors, err := parse(r.Body, genres)
+
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+
+		return
+	}
+
+	if len(movies)+len(rowErrors) == 0 {
+		app.badRequestResponse(w, r, errors.New("body must contain at least one movie"))
+		return
+	}
+
+	//rows are numbered by their position once every row is valid
+	if len(rowErrors) == 0 {
+		rowErrors = duplicateExternalIDRows(movies)
+	}
+
+	if len(rowErrors) > 0 {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, envelope{"rows": rowErrors})
+		return
+	}
+
+	if dryRun {
+		err = app.writeJSON(w, http.StatusOK, envelope{"dry_run": true, "valid_rows": len(movies)}, nil)
+
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	result, err := app.models.Movies.Import(movies, app.contextGetUser(r).ID)
+
+	if err != nil {
+		var conflictError *data.ImportConflictError
+
+		switch {
+		case errors.As(err, &conflictError):
+			for position, message := range conflictError.Conflicts {
+				rowErrors = append(rowErrors, importRowError{Row: position + 1, Errors: map[string]string{"external_ids": message}})
+			}
+
+			slices.SortFunc(rowErrors, func(a, b importRowError) int { return a.Row - b.Row })
+
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, envelope{"rows": rowErrors})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	status := http.StatusOK
+
+	if result.Created > 0 {
+		status = http.StatusCreated
+	}
+
+	err = app.writeJSON(w, status, envelope{"imported": result}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reports rows whose external ids were already given by an earlier row, which would make them import the same movie twice
+func duplicateExternalIDRows(movies []*data.Movie) []importRowError {
+	var rowErrors []importRowError
+
+	seen := make(map[string]int)
+
+	for i, movie := range movies {
+		for source, id := range movie.ExternalIDs {
+			key := source + ":" + id
+
+			if row, ok := seen[key]; ok {
+				rowErrors = append(rowErrors, importRowError{Row: i + 1, Errors: map[string]string{"external_ids": fmt.Sprintf("%s is also given by row %d", key, row)}})
+				break
+			}
+
+			seen[key] = i + 1
+		}
+	}
+
+	return rowErrors
+}
+
+// validates the movie and appends it to either movies or rowErrors
+// a movie without a status is validated as released, but keeps no status so it can't change the status of a movie it updates
+func collectImportRow(row int, movie *data.Movie, v *validator.Validator, genres *data.GenreTaxonomy, movies []*data.Movie, rowErrors []importRowError) ([]*data.Movie, []importRowError) {
+	keepStatus := movie.Status == ""
+
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
+		return movies, append(rowErrors, importRowError{Row: row, Errors: v.Errors})
+	}
+
+	if keepStatus {
+		movie.Status = ""
+	}
+
+	return append(movies, movie), rowErrors
+}
+
+// csv must have a header containing title, year, runtime and genres columns in any order
+// genres are comma separated inside a quoted field and runtime is either minutes or "<n> mins"
+// an optional status column holds released or upcoming
+// an optional external_ids column holds comma separated source:id pairs, e.g. "imdb:tt0111161,tmdb:278"
+func parseMovieCSV(body io.Reader, genres *data.GenreTaxonomy) ([]*data.Movie, []importRowError, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil, errors.New("body must not be empty")
+		}
+
+		return nil, nil, err
+	}
+
+	columns := make(map[string]int)
+
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("csv header must contain %q column", name)
+		}
+	}
+
+	var (
+		movies    []*data.Movie
+		rowErrors []importRowError
+	)
+
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if row > maxImportRows {
+			return nil, nil, fmt.Errorf("body must not contain more than %d movies", maxImportRows)
+		}
+
+		v := validator.New()
+		movie := &data.Movie{Title: record[columns["title"]]}
+
+		year, err := strconv.ParseInt(strings.TrimSpace(record[columns["year"]]), 10, 32)
+		if err != nil {
+			v.AddError("year", "must be an integer value")
+		}
+		movie.Year = int32(year)
+
+		runtime, err := data.ParseRuntime(record[columns["runtime"]])
+		if err != nil {
+			v.AddError("runtime", err.Error())
+		}
+		movie.Runtime = runtime
+
+		movie.Genres = []string{}
+		for _, genre := range strings.Split(record[columns["genres"]], ",") {
+			if genre = strings.TrimSpace(genre); genre != "" {
+				movie.Genres = append(movie.Genres, genre)
+			}
+		}
+
+		if i, ok := columns["status"]; ok {
+			movie.Status = strings.TrimSpace(record[i])
+		}
+
+		if i, ok := columns["external_ids"]; ok {
+			movie.ExternalIDs, err = parseImportExternalIDs(record[i])
+			if err != nil {
+				v.AddError("external_ids", err.Error())
+			}
+		}
+
+		movies, rowErrors = collectImportRow(row, movie, v, genres, movies, rowErrors)
+	}
+
+	return movies, rowErrors, nil
+}
+
+func parseImportExternalIDs(s string) (data.ExternalIDs, error) {
+	ids := data.ExternalIDs{}
+
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		source, id, ok := data.ParseExternalID(pair)
+
+		if !ok {
+			return nil, fmt.Errorf("%q must be in the form source:id", strings.TrimSpace(pair))
+		}
+
+		if _, exists := ids[source]; exists {
+			return nil, fmt.Errorf("must not contain more than one %s id", source)
+		}
+
+		ids[source] = id
+	}
+
+	return ids, nil
+}
+
+// each non blank line is a json object with the same fields as POST /v1/movies, and nothing else
+func parseMovieNDJSON(body io.Reader, genres *data.GenreTaxonomy) ([]*data.Movie, []importRowError, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+
+	var (
+		movies    []*data.Movie
+		rowErrors []importRowError
+	)
+
+	row := 0
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+
+		if len(line) == 0 {
+			continue
+		}
+
+		row++
+
+		if row > maxImportRows {
+			return nil, nil, fmt.Errorf("body must not contain more than %d movies", maxImportRows)
+		}
+
+		var input struct {
+			Title       string           `json:"title"`
+			Year        int32            `json:"year"`
+			Runtime     data.Runtime     `json:"runtime"`
+			Genres      []string         `json:"genres"`
+			Status      string           `json:"status"`
+			ExternalIDs data.ExternalIDs `json:"external_ids"`
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&input)
+
+		if err != nil {
+			rowErrors = append(rowErrors, importRowError{Row: row, Errors: map[string]string{"json": err.Error()}})
+			continue
+		}
+
+		//anything after the object is rejected like trailing data of other request bodies
+		if err = dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+			rowErrors = append(rowErrors, importRowError{Row: row, Errors: map[string]string{"json": "line must contain a single json object"}})
+			continue
+		}
+
+		movie := &data.Movie{
+			Title:       input.Title,
+			Year:        input.Year,
+			Runtime:     input.Runtime,
+			Genres:      input.Genres,
+			Status:      input.Status,
+			ExternalIDs: input.ExternalIDs,
+		}
+
+		movies, rowErrors = collectImportRow(row, movie, validator.New(), genres, movies, rowErrors)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return movies, rowErrors, nil
+}
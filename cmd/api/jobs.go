@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"movies.samkha.net/internal/data"
+)
+
+// periodically purges movies which have been in the trash for longer than the retention period
+// it runs for the lifetime of the process, so it isn't tracked by the background WaitGroup
+func (app *application) purgeTrash() {
+	if app.config.trash.retention <= 0 {
+		return
+	}
+
+	go func() {
+		for {
+			time.Sleep(app.config.trash.purgeInterval)
+
+			purged, keys, err := app.models.Movies.PurgeDeletedBefore(time.Now().Add(-app.config.trash.retention))
+
+			if err != nil {
+				app.logger.Error(err.Error())
+				continue
+			}
+
+			app.deleteStoredFiles(keys)
+
+			if purged > 0 {
+				app.logger.Info("purged movies from trash", "count", purged)
+			}
+		}
+	}()
+}
+
+// periodically recomputes recommendations, runs can also be triggered manually through the api
+func (app *application) scheduleRecommendations() {
+	if app.config.recommendations.interval <= 0 {
+		return
+	}
+
+	go func() {
+		for {
+			time.Sleep(app.config.recommendations.interval)
+
+			run, err := app.models.Recommendations.StartRun(data.TriggerScheduled)
+
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRunInProgress):
+					app.logger.Info("skipping scheduled recommendation run, another run is in progress")
+				default:
+					app.logger.Error(err.Error())
+				}
+
+				continue
+			}
+
+			app.runRecommendations(run)
+		}
+	}()
+}
+
+// computes recommendations and records the outcome on the run
+func (app *application) runRecommendations(run *data.RecommendationRun) {
+	users, runErr := app.models.Recommendations.Compute()
+
+	if runErr != nil {
+		app.logger.Error(runErr.Error(), "run", run.ID)
+	}
+
+	err := app.models.Recommendations.FinishRun(run, users, runErr)
+
+	if err != nil {
+		app.logger.Error(err.Error(), "run", run.ID)
+		return
+	}
+
+	app.logger.Info("recommendation run finished", "run", run.ID, "status", run.Status, "users", users)
+}
+
+// periodically turns movie changes into webhook deliveries and attempts the deliveries which are due
+// each attempt runs through the background helper so shutdown waits for the ones in flight
+func (app *application) deliverWebhooks() {
+	if app.config.webhooks.pollInterval <= 0 {
+		return
+	}
+
+	go func() {
+		for {
+			time.Sleep(app.config.webhooks.pollInterval)
+
+			//dispatches in batches until the change log is caught up
+			for {
+				dispatched, err := app.models.Webhooks.DispatchChanges(500)
+
+				if err != nil {
+					app.logger.Error(err.Error())
+				}
+
+				if err != nil || dispatched < 500 {
+					break
+				}
+			}
+
+			deliveries, err := app.models.Webhooks.ClaimDue(100)
+
+			if err != nil {
+				app.logger.Error(err.Error())
+				continue
+			}
+
+			for _, delivery := range deliveries {
+				delivery := delivery
+
+				app.background(func() {
+					app.attemptDelivery(delivery)
+				})
+			}
+		}
+	}()
+}
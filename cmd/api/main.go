@@ -16,6 +16,7 @@ import (
 	_ "github.com/lib/pq"
 	"movies.samkha.net/internal/data"
 	"movies.samkha.net/internal/mailer"
+	"movies.samkha.net/internal/storage"
 	"movies.samkha.net/internal/vcs"
 )
 
@@ -49,14 +50,47 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+
+	recommendations struct {
+		interval time.Duration
+	}
+
+	stats struct {
+		cacheTTL time.Duration
+	}
+
+	webhooks struct {
+		pollInterval time.Duration
+	}
+
+	storage struct {
+		backend string
+		baseURL string
+		dir     string
+		s3      struct {
+			endpoint  string
+			region    string
+			bucket    string
+			accessKey string
+			secretKey string
+		}
+	}
 }
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *slog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.Storage
+	stats   *statsCache
+	changes *changeBroker
+	wg      sync.WaitGroup
 }
 
 // returns connection pool or error
@@ -89,6 +123,25 @@ func openDB(cfg config) (*sql.DB, error) {
 
 }
 
+// returns the storage uploaded images are kept in
+func openStorage(cfg config) (storage.Storage, error) {
+	switch cfg.storage.backend {
+	case "local":
+		baseURL := cfg.storage.baseURL
+
+		if baseURL == "" {
+			baseURL = "/v1/images"
+		}
+
+		return storage.NewLocal(cfg.storage.dir, baseURL)
+	case "s3":
+		s3 := cfg.storage.s3
+		return storage.NewS3(s3.endpoint, s3.region, s3.bucket, s3.accessKey, s3.secretKey, cfg.storage.baseURL)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.storage.backend)
+	}
+}
+
 func main() {
 
 	var cfg config
@@ -115,6 +168,21 @@ func main() {
 		cfg.cors.trustedOrigins = strings.Fields(val)
 		return nil
 	})
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "Time deleted movies are kept in the trash before purge (0 disables purge)")
+	flag.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "Interval between trash purges")
+	flag.StringVar(&cfg.storage.backend, "storage", "local", "Storage for uploaded images (local|s3)")
+	flag.StringVar(&cfg.storage.baseURL, "storage-base-url", "", "Public url of stored images (defaults to /v1/images for local and the bucket url for s3)")
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "./uploads", "Directory images are stored in by the local storage")
+	flag.StringVar(&cfg.storage.s3.endpoint, "s3-endpoint", "", "S3 compatible endpoint, e.g. https://s3.eu-west-1.amazonaws.com or http://localhost:9000")
+	flag.StringVar(&cfg.storage.s3.region, "s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&cfg.storage.s3.bucket, "s3-bucket", "", "S3 bucket")
+	flag.StringVar(&cfg.storage.s3.accessKey, "s3-access-key", "", "S3 access key")
+	flag.StringVar(&cfg.storage.s3.secretKey, "s3-secret-key", "", "S3 secret key")
+
+	flag.DurationVar(&cfg.stats.cacheTTL, "stats-cache-ttl", 5*time.Minute, "Time computed catalog stats are served from cache (0 disables caching)")
+	flag.DurationVar(&cfg.webhooks.pollInterval, "webhooks-poll-interval", 5*time.Second, "Interval between checks for due webhook deliveries (0 disables delivery)")
+	flag.DurationVar(&cfg.recommendations.interval, "recommendations-interval", 6*time.Hour, "Interval between scheduled recommendation runs (0 disables scheduling)")
+
 	//create new version boolean flag with default to false
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
@@ -127,6 +195,14 @@ func main() {
 	}
 
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	err := validateIntervals(cfg)
+
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
 	db, err := openDB(cfg)
 
 	if err != nil {
@@ -138,11 +214,21 @@ func main() {
 
 	logger.Info("database connection pool established")
 
+	store, err := openStorage(cfg)
+
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:  cfg,
+		logger:  logger,
+		models:  data.NewModels(db),
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage: store,
+		stats:   newStatsCache(),
+		changes: newChangeBroker(),
 	}
 
 	expvar.NewString("version").Set(version)
@@ -158,6 +244,11 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	app.purgeTrash()
+	app.scheduleRecommendations()
+	app.deliverWebhooks()
+	app.listenForChanges()
+
 	err = app.server()
 
 	if err != nil {
@@ -165,3 +256,21 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// background jobs sleep for their interval between runs, an interval which isn't positive would run them in a busy loop
+// the intervals which can disable their job accept 0 for that
+func validateIntervals(cfg config) error {
+	if cfg.trash.purgeInterval <= 0 {
+		return fmt.Errorf("trash-purge-interval must be positive, got %s", cfg.trash.purgeInterval)
+	}
+
+	if cfg.webhooks.pollInterval < 0 {
+		return fmt.Errorf("webhooks-poll-interval must not be negative, got %s", cfg.webhooks.pollInterval)
+	}
+
+	if cfg.recommendations.interval < 0 {
+		return fmt.Errorf("recommendations-interval must not be negative, got %s", cfg.recommendations.interval)
+	}
+
+	return nil
+}
@@ -34,6 +34,13 @@ func (mw *metricsResponseWrite) Write(b []byte) (int, error) {
 	return mw.wrapped.Write(b)
 }
 
+// lets handlers which assert http.Flusher stream through the wrapper, ResponseController finds it through Unwrap
+func (mw *metricsResponseWrite) Flush() {
+	mw.headerWritten = true
+
+	http.NewResponseController(mw.wrapped).Flush()
+}
+
 func (mw *metricsResponseWrite) Unwrap() http.ResponseWriter {
 	return mw.wrapped
 }
@@ -165,6 +165,23 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	return app.requireAuthenticatedUser(fn)
 }
 
+// reports whether the user of the request holds the permission, for handlers which behave differently for privileged users
+func (app *application) hasPermission(r *http.Request, code string) (bool, error) {
+	user := app.contextGetUser(r)
+
+	if user.IsAnonymous() {
+		return false, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+
+	if err != nil {
+		return false, err
+	}
+
+	return permissions.Include(code), nil
+}
+
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 
 	fn := func(w http.ResponseWriter, r *http.Request) {
@@ -188,6 +205,31 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivatedUser(fn)
 }
 
+// reads the runtime_format query parameter every response containing movies honours
+func (app *application) runtimeFormat(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+
+		if !qs.Has("runtime_format") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		format := data.RuntimeFormat(qs.Get("runtime_format"))
+
+		v := validator.New()
+
+		v.Check(format != data.RuntimeDefault && validator.PermittedValue(format, data.RuntimeFormats...), "runtime_format", "must be one of iso8601, minutes or human")
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		next.ServeHTTP(w, app.contextSetRuntimeFormat(r, format))
+	})
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Origin")
@@ -209,7 +251,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 					// it as a preflight request.
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match")
 
 						w.WriteHeader(http.StatusOK)
 						return
@@ -243,8 +285,8 @@ func (app *application) metrics(next http.Handler) http.Handler {
 
 		mw := newMetricsResponseWrite(w)
 
-		//next handler in the change
-		next.ServeHTTP(w, r)
+		//next handler in the change, handed the wrapper so the status it writes is recorded
+		next.ServeHTTP(mw, r)
 
 		//on the way backup the middleware chain, increment number of response sent by 1.
 		totalResponseSent.Add(1)
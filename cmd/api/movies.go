@@ -3,43 +3,210 @@ package main
 import (
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/jsonpatch"
 	"movies.samkha.net/internal/validator"
 )
 
+// reads the search, sort and pagination parameters shared by every endpoint listing movies
+// titles are searched in the language of the client's preferred locale next to the original title
+func (app *application) readMovieSearch(r *http.Request, v *validator.Validator) (data.MovieSearch, data.Filters) {
+	var search data.MovieSearch
+	var filters data.Filters
+
+	qs := r.URL.Query()
+
+	search.Title = app.readString(qs, "title", "")
+	search.Genres = app.readCSV(qs, "genres", []string{})
+	search.PersonID = int64(app.readInt(qs, "person", 0, v))
+
+	if qs.Has("external_id") {
+		source, id, ok := data.ParseExternalID(qs.Get("external_id"))
+		v.Check(ok, "external_id", "must be in the form source:id, e.g. imdb:tt0111161")
+
+		search.ExternalSource, search.ExternalID = source, id
+	}
+
+	search.ReleasedIn = strings.ToUpper(app.readString(qs, "released_in", ""))
+	v.Check(search.ReleasedIn == "" || validator.Matches(search.ReleasedIn, data.CountryRx), "released_in", "must be a two letter country code")
+
+	//certification<=12 reaches us as the key "certification<" with the value "12"
+	if qs.Has("certification<") {
+		age, err := strconv.Atoi(qs.Get("certification<"))
+		v.Check(err == nil && age >= 0, "certification", "must be a minimum age, e.g. certification<=12")
+
+		search.MaxAge = &age
+	}
+
+	v.Check(!qs.Has("certification"), "certification", "must be compared to a minimum age, e.g. certification<=12")
+
+	search.Status = app.readString(qs, "status", "")
+	v.Check(search.Status == "" || validator.PermittedValue(search.Status, data.MovieReleased, data.MovieUpcoming), "status", "must be released or upcoming")
+
+	if locales := app.readLocales(r); len(locales) > 0 {
+		search.SearchConfig = data.SearchConfig(locales[0])
+	}
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "id")
+	filters.SortSafeList = []string{"id", "title", "year", "runtime", "rating", "-id", "-title", "-year", "-runtime", "-rating"}
+
+	return search, filters
+}
+
+// sets the runtime format requested with runtime_format on the movies
+func (app *application) formatMovies(r *http.Request, movies ...*data.Movie) {
+	format := app.contextGetRuntimeFormat(r)
+
+	for _, movie := range movies {
+		movie.RuntimeFormat = format
+	}
+}
+
+// shows the movies in the client's preferred locale and sets the per user fields when the request is authenticated
+func (app *application) annotateMovies(w http.ResponseWriter, r *http.Request, movies ...*data.Movie) error {
+	app.formatMovies(r, movies...)
+	addVary(w, "Accept-Language")
+
+	//etags of annotated movies hash the per user fields, so caches must not share them between users
+	//authenticate adds it to every response already, it's repeated so this doesn't depend on the middleware
+	addVary(w, "Authorization")
+
+	err := app.models.Translations.Localise(app.readLocales(r), movies...)
+
+	if err != nil {
+		return err
+	}
+
+	user := app.contextGetUser(r)
+
+	if user.IsAnonymous() {
+		return nil
+	}
+
+	return app.models.Watchlists.Annotate(user.ID, movies...)
+}
+
 func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request) {
 	//to hold values from query string
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieSearch
 		data.Filters
 	}
 
 	v := validator.New()
 	qs := r.URL.Query()
 
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
-	input.Filters.Page = app.readInt(qs, "page", 1, v)
-	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	//fetching by ids replaces the search, so other filters don't apply
+	if qs.Has("ids") {
+		app.listMoviesByIDs(w, r)
+		return
+	}
+
+	input.MovieSearch, input.Filters = app.readMovieSearch(r, v)
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieSearch, input.Filters)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	err = app.annotateMovies(w, r, movies...)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"movies": movies, "metadata": metadata}
+
+	etag, err := hashETag(env)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.notModified(w, r, etag) {
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// responds with the movies in the order of the ids parameter, and the ids which don't exist
+func (app *application) listMoviesByIDs(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	ids := app.readIDs(r.URL.Query(), "ids", v)
+
+	v.Check(len(ids) >= 1, "ids", "must contain at least 1 id")
+	v.Check(len(ids) <= 100, "ids", "must not contain more than 100 ids")
+	v.Check(validator.Unique(ids), "ids", "must not contain duplicate values")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, err := app.models.Movies.GetByIDs(ids)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.annotateMovies(w, r, movies...)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	found := make(map[int64]bool, len(movies))
+
+	for _, movie := range movies {
+		found[movie.ID] = true
+	}
+
+	notFound := []int64{}
+
+	for _, id := range ids {
+		if !found[id] {
+			notFound = append(notFound, id)
+		}
+	}
+
+	env := envelope{"movies": movies, "not_found": notFound}
+
+	etag, err := hashETag(env)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.notModified(w, r, etag) {
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -48,10 +215,12 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
+		Title       string           `json:"title"`
+		Year        int32            `json:"year"`
+		Runtime     data.Runtime     `json:"runtime"`
+		Genres      []string         `json:"genres"`
+		Status      string           `json:"status"`
+		ExternalIDs data.ExternalIDs `json:"external_ids"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -62,28 +231,47 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:       input.Title,
+		Year:        input.Year,
+		Runtime:     input.Runtime,
+		Genres:      input.Genres,
+		Status:      input.Status,
+		ExternalIDs: input.ExternalIDs,
+	}
+
+	genres, err := app.models.Genres.Taxonomy()
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(movie, app.contextGetUser(r).ID)
 
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_ids", "must not contain an identifier of another movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
 		return
 	}
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", versionETag(movie.Version))
+
+	app.formatMovies(r, movie)
 
 	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
 
@@ -115,16 +303,64 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	w.Header().Set("Accept-Patch", strings.Join([]string{"application/json", mergePatchType, jsonPatchType}, ", "))
+
+	movie.Credits, err = app.models.People.GetCreditsForMovie(movie.ID)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-}
+	movie.ExternalIDs, err = app.models.ExternalIDs.GetForMovie(movie.ID)
 
-func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
+	movie.Releases, err = app.models.Releases.GetForMovie(movie.ID)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.annotateMovies(w, r, movie)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"movie": movie}
+
+	//ratings and the user's watchlist don't bump the version, so the etag hashes the body as it's written
+	etag, err := representationETag(movie.Version, env)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if movie.Locale != "" {
+		w.Header().Set("Content-Language", movie.Locale)
+	}
+
+	if app.notModified(w, r, etag) {
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+
+}
+
+// movies ranked by similarity to the movie, most similar first
+func (app *application) listSimilarMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIdParam(r)
 
 	if err != nil {
@@ -132,10 +368,26 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	var input struct {
+		data.Filters
+	}
 
-	if err != nil {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = "-score"
+	input.Filters.SortSafeList = []string{"-score"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id)
 
+	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
@@ -146,56 +398,92 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// round-trip locking: to help ensure client is not working with outdated information
-	//client can send If-Not-Match or X-Expected-Version header
-	//
+	movies, metadata, err := app.models.Movies.GetSimilar(id, input.Filters)
 
-	// if r.Header.Get("X-Expected-Version") != "" {
-	// 	if strconv.FormatInt(int64(movie.Version), 32) != r.Header.Get("X-Expected-Version") {
-	// 		app.editConflictResponse(w, r)
-	// 		return
-	// 	}
-	// }
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	//falsy value for pointer is nil so change every attr to pointer
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+	err = app.annotateMovies(w, r, movies...)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 	}
+}
 
-	err = app.readJSON(w, r, &input)
+func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIdParam(r)
 
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.notFoundResponse(w, r)
 		return
 	}
 
-	if input.Title != nil {
-		movie.Title = *input.Title
+	movie, err := app.models.Movies.Get(id)
+
+	if err != nil {
+
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	// round-trip locking: to help ensure client is not working with outdated information
+	//client sends back the ETag it received in If-Match header
+	if !app.preconditionMet(r, versionETag(movie.Version)) {
+		app.preconditionFailedResponse(w, r)
+		return
 	}
 
-	if input.Year != nil {
-		movie.Year = *input.Year
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case mergePatchType, jsonPatchType:
+		err = app.patchMovie(w, r, mediaType, movie)
+	default:
+		err = app.readMovieChanges(w, r, movie)
 	}
 
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
+	if err != nil {
+		switch {
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+
+		return
 	}
 
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+	genres, err := app.models.Genres.Taxonomy()
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(movie, app.contextGetUser(r).ID)
 
 	if err != nil {
 		switch {
@@ -208,7 +496,12 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(movie.Version))
+
+	app.formatMovies(r, movie)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -225,13 +518,42 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	//0 deletes whatever the version
+	var version int32
+
+	//only fetch the movie when the client asked for round-trip locking
+	//the matched version is passed on to the delete, so an update in between fails it instead of being deleted over
+	if r.Header.Get("If-Match") != "" {
+		movie, err := app.models.Movies.Get(id)
+
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+
+			return
+		}
+
+		if !app.preconditionMet(r, versionETag(movie.Version)) {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+
+		version = movie.Version
+	}
+
+	err = app.models.Movies.Delete(id, version, app.contextGetUser(r).ID)
 
 	if err != nil {
 
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -246,3 +568,104 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 }
+
+func (app *application) listTrashedMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafeList = []string{"id", "title", "deleted_at", "-id", "-title", "-deleted_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetAllDeleted(input.Filters)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.formatMovies(r, movies...)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Restore(id, app.contextGetUser(r).ID)
+
+	if err != nil {
+
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(movie.Version))
+
+	app.formatMovies(r, movie)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) purgeMovieHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	keys, err := app.models.Movies.Purge(id)
+
+	if err != nil {
+
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	app.deleteStoredFiles(keys)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie permanently deleted"}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
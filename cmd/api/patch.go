@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/jsonpatch"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// the fields of a movie clients can change, which is the document patches are applied to
+type moviePatchDocument struct {
+	Title   string       `json:"title"`
+	Year    int32        `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+	Status  string       `json:"status"`
+}
+
+// applies a plain json body to the movie, only the fields present are changed
+func (app *application) readMovieChanges(w http.ResponseWriter, r *http.Request, movie *data.Movie) error {
+	//falsy value for pointer is nil so change every attr to pointer
+	var input struct {
+		Title   *string       `json:"title"`
+		Year    *int32        `json:"year"`
+		Runtime *data.Runtime `json:"runtime"`
+		Genres  []string      `json:"genres"`
+		Status  *string       `json:"status"`
+	}
+
+	err := app.readJSON(w, r, &input)
+
+	if err != nil {
+		return err
+	}
+
+	if input.Title != nil {
+		movie.Title = *input.Title
+	}
+
+	if input.Year != nil {
+		movie.Year = *input.Year
+	}
+
+	if input.Runtime != nil {
+		movie.Runtime = *input.Runtime
+	}
+
+	if input.Genres != nil {
+		movie.Genres = input.Genres
+	}
+
+	if input.Status != nil {
+		movie.Status = *input.Status
+	}
+
+	return nil
+}
+
+// applies a json merge patch (RFC 7396) or json patch (RFC 6902) body to the movie
+// members removed by the patch are left empty for validation to report
+func (app *application) patchMovie(w http.ResponseWriter, r *http.Request, mediaType string, movie *data.Movie) error {
+	doc, err := json.Marshal(moviePatchDocument{
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+		Status:  movie.Status,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	switch mediaType {
+	case mergePatchType:
+		var patch json.RawMessage
+
+		err = app.readJSON(w, r, &patch)
+
+		if err != nil {
+			return err
+		}
+
+		doc, err = jsonpatch.MergePatch(doc, patch)
+	default:
+		var ops []jsonpatch.Operation
+
+		err = app.readJSON(w, r, &ops)
+
+		if err != nil {
+			return err
+		}
+
+		doc, err = jsonpatch.Apply(doc, ops)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var patched moviePatchDocument
+
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&patched)
+
+	if err != nil {
+		return fmt.Errorf("patched movie is not a valid movie: %w", err)
+	}
+
+	movie.Title = patched.Title
+	movie.Year = patched.Year
+	movie.Runtime = patched.Runtime
+	movie.Genres = patched.Genres
+	movie.Status = patched.Status
+
+	return nil
+}
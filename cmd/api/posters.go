@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/imaging"
+	"movies.samkha.net/internal/validator"
+)
+
+const (
+	maxPosterBytes  = 10 << 20
+	maxPosterPixels = 50_000_000
+)
+
+// thumbnail widths, widest first as each one is scaled down from the previous
+var posterSizes = []struct {
+	name  string
+	width int
+}{
+	{"large", 780},
+	{"medium", 342},
+	{"small", 154},
+}
+
+var posterExtensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+}
+
+// a stored rendition of the poster
+type posterFile struct {
+	size        string
+	extension   string
+	contentType string
+	data        []byte
+}
+
+// accepts a multipart/form-data body with the image in the poster field
+// the original is stored as uploaded next to jpeg thumbnails of every size
+func (app *application) putMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	if !app.preconditionMet(r, versionETag(movie.Version)) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType != "multipart/form-data" {
+		app.unsupportedMediaTypeResponse(w, r, "multipart/form-data")
+		return
+	}
+
+	//leave room for the multipart framing around the image
+	r.Body = http.MaxBytesReader(w, r.Body, maxPosterBytes+64<<10)
+
+	v := validator.New()
+
+	upload, declaredType, err := readPosterPart(r)
+
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			v.AddError("poster", fmt.Sprintf("must not be larger than %d bytes", maxPosterBytes))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+
+		return
+	}
+
+	contentType := http.DetectContentType(upload)
+	_, supported := posterExtensions[contentType]
+
+	v.Check(upload != nil, "poster", "must be provided")
+	v.Check(len(upload) <= maxPosterBytes, "poster", fmt.Sprintf("must not be larger than %d bytes", maxPosterBytes))
+	v.Check(upload == nil || supported, "poster", "must be a jpeg or png image")
+	v.Check(declaredType == "" || declaredType == "application/octet-stream" || declaredType == contentType, "poster", "content type does not match the image")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//check the dimensions before decoding, so a small file can't unpack into a huge image
+	config, _, err := image.DecodeConfig(bytes.NewReader(upload))
+
+	if err == nil && config.Width*config.Height > maxPosterPixels {
+		v.AddError("poster", fmt.Sprintf("must not have more than %d pixels", maxPosterPixels))
+	}
+
+	var img image.Image
+
+	if err == nil && v.Valid() {
+		img, _, err = image.Decode(bytes.NewReader(upload))
+	}
+
+	if err != nil {
+		v.AddError("poster", "must be a valid image")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	files, err := posterFiles(upload, contentType, img)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	keys, urls, err := app.storePoster(r.Context(), movie.ID, files)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	previous, err := app.models.Movies.SetPoster(movie, urls, keys, app.contextGetUser(r).ID)
+
+	if err != nil {
+		app.deleteStoredFiles(keys)
+
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	app.deleteStoredFiles(previous)
+
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(movie.Version))
+
+	app.formatMovies(r, movie)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	if movie.Poster == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.preconditionMet(r, versionETag(movie.Version)) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	previous, err := app.models.Movies.SetPoster(movie, nil, []string{}, app.contextGetUser(r).ID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	app.deleteStoredFiles(previous)
+
+	//removing the poster bumps the version, so clients need the new etag for their next conditional write
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(movie.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "poster successfully deleted"}, headers)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// returns the content of the poster field and the content type its part declares
+func readPosterPart(r *http.Request) ([]byte, string, error) {
+	reader, err := r.MultipartReader()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	for {
+		part, err := reader.NextPart()
+
+		if errors.Is(err, io.EOF) {
+			return nil, "", nil
+		}
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		if part.FormName() != "poster" {
+			continue
+		}
+
+		//one byte over the limit is enough to tell the upload is too large
+		content, err := io.ReadAll(io.LimitReader(part, maxPosterBytes+1))
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+
+		return content, mediaType, nil
+	}
+}
+
+// the original followed by a jpeg thumbnail of every size
+func posterFiles(upload []byte, contentType string, img image.Image) ([]posterFile, error) {
+	files := []posterFile{{size: "original", extension: posterExtensions[contentType], contentType: contentType, data: upload}}
+
+	for _, size := range posterSizes {
+		img = imaging.Resize(img, size.width)
+
+		thumbnail, err := imaging.EncodeJPEG(img, 85)
+
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, posterFile{size: size.name, extension: "jpg", contentType: "image/jpeg", data: thumbnail})
+	}
+
+	return files, nil
+}
+
+// writes the files under a fresh prefix, so urls of a replaced poster never serve the new image from a cache
+// nothing is left behind in storage when writing fails
+func (app *application) storePoster(ctx context.Context, movieID int64, files []posterFile) ([]string, data.ImageURLs, error) {
+	token := make([]byte, 8)
+
+	_, err := rand.Read(token)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prefix := fmt.Sprintf("posters/%d/%s", movieID, hex.EncodeToString(token))
+
+	keys := make([]string, 0, len(files))
+	urls := make(data.ImageURLs, len(files))
+
+	for _, file := range files {
+		key := prefix + "/" + file.size + "." + file.extension
+
+		err := app.storage.Put(ctx, key, file.contentType, file.data)
+
+		if err != nil {
+			app.deleteStoredFiles(keys)
+			return nil, nil, err
+		}
+
+		keys = append(keys, key)
+		urls[file.size] = app.storage.URL(key)
+	}
+
+	return keys, urls, nil
+}
+
+// removes files from storage in the background, failures only leave unreferenced files behind
+func (app *application) deleteStoredFiles(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		for _, key := range keys {
+			err := app.storage.Delete(ctx, key)
+
+			if err != nil {
+				app.logger.Error(err.Error(), "key", key)
+			}
+		}
+	})
+}
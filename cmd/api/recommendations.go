@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/validator"
+)
+
+// precomputed recommendations of the current user, falling back to popular movies in their favoured genres
+// until the recommendations job has something for them
+func (app *application) listRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = "-score"
+	input.Filters.SortSafeList = []string{"-score"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	computed, err := app.models.Recommendations.Exists(user.ID)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var (
+		movies   []*data.Movie
+		metadata data.MetaData
+		source   = "collaborative"
+	)
+
+	if computed {
+		movies, metadata, err = app.models.Recommendations.GetForUser(user.ID, input.Filters)
+	} else {
+		source = "popular"
+		movies, metadata, err = app.models.Recommendations.GetPopular(user.ID, input.Filters)
+	}
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.annotateMovies(w, r, movies...)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recommendations": movies, "source": source, "metadata": metadata}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// starts a run in the background, its progress can be followed at the returned location
+func (app *application) createRecommendationRunHandler(w http.ResponseWriter, r *http.Request) {
+	run, err := app.models.Recommendations.StartRun(data.TriggerManual)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRunInProgress):
+			app.errorResponse(w, r, http.StatusConflict, "a recommendation run is already in progress")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	app.background(func() {
+		app.runRecommendations(run)
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/recommendations/runs/%d", run.ID))
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"run": run}, headers)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listRecommendationRunsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-started_at")
+	input.Filters.SortSafeList = []string{"id", "started_at", "-id", "-started_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	runs, metadata, err := app.models.Recommendations.GetAllRuns(input.Filters)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"runs": runs, "metadata": metadata}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showRecommendationRunHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	run, err := app.models.Recommendations.GetRun(id)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"run": run}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
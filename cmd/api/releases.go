@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/validator"
+)
+
+// replaces every release of the movie, certifications of known rating systems must be valid for the country
+func (app *application) updateMovieReleasesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	if !app.preconditionMet(r, versionETag(movie.Version)) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Releases []*data.Release `json:"releases"`
+	}
+
+	err = app.readJSON(w, r, &input)
+
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Releases != nil, "releases", "must be provided")
+
+	if data.ValidateReleases(v, input.Releases); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Releases.Replace(movie, input.Releases, app.contextGetUser(r).ID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	movie.Releases, err = app.models.Releases.GetForMovie(movie.ID)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(movie.Version))
+
+	app.formatMovies(r, movie)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/validator"
+)
+
+// fetches the review addressed by :id and :review_id, writing the error response if it can't
+// reviews of movies in the trash aren't found, like their movies
+func (app *application) reviewFromRequest(w http.ResponseWriter, r *http.Request) (*data.Review, bool) {
+	movieID, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	_, err = app.models.Movies.Get(movieID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return nil, false
+	}
+
+	id, err := app.readInt64Param(r, "review_id")
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	review, err := app.models.Reviews.Get(movieID, id)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return nil, false
+	}
+
+	return review, true
+}
+
+func (app *application) listMovieReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		IncludeHidden bool
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.IncludeHidden = app.readBool(qs, "include_hidden", false, v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafeList = []string{"id", "created_at", "updated_at", "-id", "-created_at", "-updated_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//only moderators get to see hidden reviews
+	if input.IncludeHidden {
+		moderator, err := app.hasPermission(r, "reviews:moderate")
+
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !moderator {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	_, err = app.models.Movies.Get(movieID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	reviews, metadata, err := app.models.Reviews.GetAllForMovie(movieID, input.IncludeHidden, input.Filters)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reviews": reviews, "metadata": metadata}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createMovieReviewHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	//reviews are only taken for movies which can be seen, not for unknown ones or those in the trash
+	_, err = app.models.Movies.Get(movieID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	var input struct {
+		Title string `json:"title"`
+		Body  string `json:"body"`
+	}
+
+	err = app.readJSON(w, r, &input)
+
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	review := &data.Review{
+		MovieID: movieID,
+		UserID:  app.contextGetUser(r).ID,
+		Title:   input.Title,
+		Body:    input.Body,
+	}
+
+	v := validator.New()
+
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reviews.Insert(review)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReview):
+			v.AddError("review", "you have already reviewed this movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d/reviews/%d", movieID, review.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"review": review}, headers)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// hidden reviews are only visible to their authors and moderators
+func (app *application) reviewVisible(r *http.Request, review *data.Review) (bool, error) {
+	if !review.Hidden || review.UserID == app.contextGetUser(r).ID {
+		return true, nil
+	}
+
+	return app.hasPermission(r, "reviews:moderate")
+}
+
+func (app *application) showMovieReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.reviewFromRequest(w, r)
+
+	if !ok {
+		return
+	}
+
+	visible, err := app.reviewVisible(r, review)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !visible {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// only the author can change the text of a review
+func (app *application) updateMovieReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.reviewFromRequest(w, r)
+
+	if !ok {
+		return
+	}
+
+	if review.UserID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Title *string `json:"title"`
+		Body  *string `json:"body"`
+	}
+
+	err := app.readJSON(w, r, &input)
+
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Title != nil {
+		review.Title = *input.Title
+	}
+
+	if input.Body != nil {
+		review.Body = *input.Body
+	}
+
+	v := validator.New()
+
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reviews.Update(review)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// authors can remove their own reviews, moderators can remove anyone's
+func (app *application) deleteMovieReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.reviewFromRequest(w, r)
+
+	if !ok {
+		return
+	}
+
+	if review.UserID != app.contextGetUser(r).ID {
+		moderator, err := app.hasPermission(r, "reviews:moderate")
+
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !moderator {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	err := app.models.Reviews.Delete(review.ID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "review successfully deleted"}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reviews which can't be seen can't be reported either, hidden ones aren't found
+func (app *application) reportMovieReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.reviewFromRequest(w, r)
+
+	if !ok {
+		return
+	}
+
+	visible, err := app.reviewVisible(r, review)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !visible {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err = app.readJSON(w, r, &input)
+
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	report := &data.ReviewReport{
+		ReviewID: review.ID,
+		UserID:   app.contextGetUser(r).ID,
+		Reason:   input.Reason,
+	}
+
+	v := validator.New()
+
+	if data.ValidateReviewReport(v, report); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reviews.InsertReport(report)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReport):
+			v.AddError("report", "you have already reported this review")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"report": report}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// hides or unhides a review, either way its open reports are resolved
+func (app *application) moderateMovieReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.reviewFromRequest(w, r)
+
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Hidden *bool  `json:"hidden"`
+		Reason string `json:"reason"`
+	}
+
+	err := app.readJSON(w, r, &input)
+
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Hidden != nil, "hidden", "must be provided")
+	v.Check(input.Hidden == nil || !*input.Hidden || validator.NotBlank(input.Reason), "reason", "must be provided when hiding a review")
+	v.Check(validator.MaxChars(input.Reason, 1000), "reason", "must not be more than 1000 characters long")
+	v.Check(validator.PrintableText(input.Reason), "reason", "must not contain control characters")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	review.Hidden = *input.Hidden
+	review.HiddenReason = ""
+
+	if review.Hidden {
+		review.HiddenReason = input.Reason
+	}
+
+	err = app.models.Reviews.Update(review)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.models.Reviews.DeleteReports(review.ID)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listReportedReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = "-report_count"
+	input.Filters.SortSafeList = []string{"-report_count"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := app.models.Reviews.GetAllReported(input.Filters)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reviews": reviews, "metadata": metadata}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
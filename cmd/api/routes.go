@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"movies.samkha.net/internal/storage"
 )
 
 func (app *application) routes() http.Handler {
@@ -15,16 +16,116 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthchekHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMovieHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id", app.staticSegments(map[string]http.HandlerFunc{
+		"import": app.requirePermission("movies:write", app.importMoviesHandler),
+		"batch":  app.requirePermission("movies:write", app.batchMoviesHandler),
+	}, app.methodNotAllowedResponse))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.staticSegments(map[string]http.HandlerFunc{
+		"trash":      app.requirePermission("movies:write", app.listTrashedMoviesHandler),
+		"export":     app.requirePermission("movies:read", app.exportMoviesHandler),
+		"duplicates": app.requirePermission("movies:read", app.listDuplicateMoviesHandler),
+		"changes":    app.requirePermission("movies:read", app.listMovieChangesHandler),
+		"stream":     app.requirePermission("movies:read", app.streamMoviesHandler),
+	}, app.requirePermission("movies:read", app.showMovieHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/similar", app.requirePermission("movies:read", app.listSimilarMoviesHandler))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission("movies:write", app.restoreMovieHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/purge", app.requirePermission("movies:purge", app.purgeMovieHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission("movies:read", app.listMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions/:version", app.requirePermission("movies:read", app.showMovieRevisionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revisions/:version/revert", app.requirePermission("movies:write", app.revertMovieHandler))
+
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/rating", app.requireActivatedUser(app.rateMovieHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/rating", app.requireActivatedUser(app.deleteMovieRatingHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listMovieReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requireActivatedUser(app.createMovieReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.showMovieReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/reviews/:review_id", app.requireActivatedUser(app.updateMovieReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews/:review_id", app.requireActivatedUser(app.deleteMovieReviewHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews/:review_id/reports", app.requireActivatedUser(app.reportMovieReviewHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/reviews/:review_id/moderation", app.requirePermission("reviews:moderate", app.moderateMovieReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/reviews/reported", app.requirePermission("reviews:moderate", app.listReportedReviewsHandler))
+
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.putMoviePosterHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.deleteMoviePosterHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/translations", app.requirePermission("movies:read", app.listMovieTranslationsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/translations/:locale", app.requirePermission("movies:write", app.putMovieTranslationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/translations/:locale", app.requirePermission("movies:write", app.deleteMovieTranslationHandler))
+
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/credits", app.requirePermission("movies:write", app.updateMovieCreditsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/external_ids", app.requirePermission("movies:write", app.updateMovieExternalIDsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/releases", app.requirePermission("movies:write", app.updateMovieReleasesHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/stats/movies", app.requirePermission("stats:read", app.showMovieStatsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("movies:read", app.listGenresHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requirePermission("movies:write", app.createGenreHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission("movies:read", app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("movies:write", app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission("movies:read", app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("movies:write", app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("movies:write", app.deletePersonHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
 
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.listUserMoviesHandler(false)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/watchlist/:movie_id", app.requireActivatedUser(app.addToWatchlistHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlist/:movie_id", app.requireActivatedUser(app.removeFromWatchlistHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watched", app.requirePermission("movies:read", app.listUserMoviesHandler(true)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/watched/:movie_id", app.requireActivatedUser(app.markWatchedHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watched/:movie_id", app.requireActivatedUser(app.unmarkWatchedHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/recommendations", app.requirePermission("movies:read", app.listRecommendationsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/recommendations/runs", app.requirePermission("recommendations:manage", app.listRecommendationRunsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/recommendations/runs", app.requirePermission("recommendations:manage", app.createRecommendationRunHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/recommendations/runs/:id", app.requirePermission("recommendations:manage", app.showRecommendationRunHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/collections", app.listCollectionsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/collections", app.requireActivatedUser(app.createCollectionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:id", app.showCollectionHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/collections/:id", app.requireActivatedUser(app.updateCollectionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id", app.requireActivatedUser(app.deleteCollectionHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/collections/:id/order", app.requireActivatedUser(app.reorderCollectionHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/collections/:id/entries/:movie_id", app.requireActivatedUser(app.putCollectionEntryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id/entries/:movie_id", app.requireActivatedUser(app.deleteCollectionEntryHandler))
+
+	//images in the local storage are served by the api, other storages serve them themselves
+	if local, ok := app.storage.(*storage.Local); ok {
+		router.Handler(http.MethodGet, "/v1/images/*filepath", http.StripPrefix("/v1/images", local.Handler()))
+	}
+
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks", app.requirePermission("webhooks:manage", app.listWebhooksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks", app.requirePermission("webhooks:manage", app.createWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id", app.requirePermission("webhooks:manage", app.showWebhookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", app.requirePermission("webhooks:manage", app.deleteWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries", app.requirePermission("webhooks:manage", app.listWebhookDeliveriesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries/:delivery_id", app.requirePermission("webhooks:manage", app.showWebhookDeliveryHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks/:id/deliveries/:delivery_id/redeliver", app.requirePermission("webhooks:manage", app.redeliverWebhookDeliveryHandler))
+
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthTokenHandler)
 
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(app.runtimeFormat(router))))))
+}
+
+// httprouter doesn't allow static path segments next to the :id wildcard,
+// so static sub resources are dispatched by value of :id before falling back to the wildcard handler
+func (app *application) staticSegments(handlers map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if handler, ok := handlers[params.ByName("id")]; ok {
+			handler(w, r)
+			return
+		}
+
+		next(w, r)
+	}
 }
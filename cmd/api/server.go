@@ -22,6 +22,9 @@ func (app *application) server() error {
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 	}
 
+	//movie streams never go idle, so they are ended for Shutdown to stop waiting on them
+	srv.RegisterOnShutdown(app.changes.close)
+
 	shutDownError := make(chan error)
 
 	// start a background goroutine
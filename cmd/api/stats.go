@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/validator"
+)
+
+// entries past this are dropped at once, so scripted requests with ever changing filters can't grow the cache without bound
+const maxStatsCacheEntries = 1000
+
+// computed stats by the search they were computed for, shared by every client for the cache ttl
+type statsCache struct {
+	mu      sync.Mutex
+	entries map[string]statsCacheEntry
+}
+
+type statsCacheEntry struct {
+	stats   *data.MovieStats
+	expires time.Time
+}
+
+func newStatsCache() *statsCache {
+	return &statsCache{entries: make(map[string]statsCacheEntry)}
+}
+
+func (c *statsCache) get(key string) (*data.MovieStats, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+
+	if !ok || time.Now().After(entry.expires) {
+		return nil, time.Time{}, false
+	}
+
+	return entry.stats, entry.expires, true
+}
+
+func (c *statsCache) set(key string, stats *data.MovieStats, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	if len(c.entries) >= maxStatsCacheEntries {
+		clear(c.entries)
+	}
+
+	c.entries[key] = statsCacheEntry{stats: stats, expires: expires}
+}
+
+// counts by genre and decade, average runtime by genre and movies added per week
+// accepts the search parameters of GET /v1/movies, the weeks parameter sets how many weeks of additions are returned
+func (app *application) showMovieStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.MovieSearch
+		Weeks int
+	}
+
+	v := validator.New()
+
+	input.MovieSearch, _ = app.readMovieSearch(r, v)
+	input.Weeks = app.readInt(r.URL.Query(), "weeks", 12, v)
+
+	v.Check(input.Weeks >= 1 && input.Weeks <= 104, "weeks", "must be between 1 and 104")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	key, err := json.Marshal(input)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	stats, expires, ok := app.stats.get(string(key))
+
+	if !ok {
+		stats, err = app.models.Stats.GetMovieStats(input.MovieSearch, input.Weeks)
+
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		expires = time.Now().Add(app.config.stats.cacheTTL)
+
+		if app.config.stats.cacheTTL > 0 {
+			app.stats.set(string(key), stats, expires)
+		}
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", max(0, int(time.Until(expires).Seconds()))))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stats": stats}, headers)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/validator"
+)
+
+// channel the change log trigger notifies once per transaction which recorded changes
+const movieChangesChannel = "movie_changes"
+
+const (
+	streamHeartbeat    = 15 * time.Second // comment lines keep proxies from closing quiet streams
+	streamWriteTimeout = 30 * time.Second // replaces the server WriteTimeout, extended on every write
+	streamAuthInterval = time.Minute      // how often a stream checks that its user may still read movies
+	streamBatchSize    = 100
+)
+
+// wakes the movie streams of this instance whenever any instance records movie changes
+type changeBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan struct{}]struct{}
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+func newChangeBroker() *changeBroker {
+	return &changeBroker{
+		subscribers: make(map[chan struct{}]struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// returned channel receives a value when there may be new changes, wake ups arriving while one is pending are folded into it
+func (b *changeBroker) subscribe() chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wake := make(chan struct{}, 1)
+	b.subscribers[wake] = struct{}{}
+
+	return wake
+}
+
+func (b *changeBroker) unsubscribe(wake chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers, wake)
+}
+
+func (b *changeBroker) broadcast() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for wake := range b.subscribers {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ends every stream, graceful shutdown waits for connections to go idle which streams never do
+func (b *changeBroker) close() {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+}
+
+// listens for change notifications on a connection of its own for the lifetime of the process, like the other jobs
+func (app *application) listenForChanges() {
+	listener := pq.NewListener(app.config.db.dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	go func() {
+		//blocks until the listener is connected
+		err := listener.Listen(movieChangesChannel)
+
+		if err != nil {
+			app.logger.Error(err.Error())
+			return
+		}
+
+		for {
+			select {
+			//a nil notification follows a reconnect, changes may have been missed so streams are woken all the same
+			case <-listener.Notify:
+				app.changes.broadcast()
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+}
+
+// re-authenticates the request of a stream, so the stream ends once its token expires or the user loses the permission
+func (app *application) streamPermitted(r *http.Request) (bool, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	if !user.Activated {
+		return false, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+
+	if err != nil {
+		return false, err
+	}
+
+	return permissions.Include("movies:read"), nil
+}
+
+// streams changes of the catalog as server-sent events, the id of each event is the sequence of its change
+// a client reconnecting with Last-Event-ID, or the last_event_id parameter, receives the changes it missed
+// without either only changes made after connecting are sent
+func (app *application) streamMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	var (
+		since int64
+		err   error
+	)
+
+	if lastEventID != "" {
+		since, err = strconv.ParseInt(lastEventID, 10, 64)
+		v.Check(err == nil && since >= 0, "last_event_id", "must be the id of a previous event")
+	} else {
+		since, err = app.models.Changes.Latest()
+
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	wake := app.changes.subscribe()
+	defer app.changes.unsubscribe(wake)
+
+	rc := http.NewResponseController(w)
+
+	// every write pushes the deadline forward, so only a client which stops reading runs into it
+	send := func(event string) error {
+		err := rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(w, event)
+
+		if err != nil {
+			return err
+		}
+
+		return rc.Flush()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	err = send("retry: 5000\n\n")
+
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	authCheck := time.NewTicker(streamAuthInterval)
+	defer authCheck.Stop()
+
+	for {
+		//the log is read on heartbeats too, which covers notifications lost while the listener reconnected
+		for {
+			changes, err := app.models.Changes.GetSince(since, streamBatchSize)
+
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+
+			for _, change := range changes {
+				if change.Movie != nil {
+					change.Movie.RuntimeFormat = app.contextGetRuntimeFormat(r)
+				}
+
+				js, err := json.Marshal(change)
+
+				if err != nil {
+					app.logError(r, err)
+					return
+				}
+
+				err = send(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", change.Sequence, data.ChangeEvents[change.Operation], js))
+
+				if err != nil {
+					app.logError(r, err)
+					return
+				}
+
+				since = change.Sequence
+			}
+
+			if len(changes) < streamBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-wake:
+		case <-heartbeat.C:
+			err = send(": heartbeat\n\n")
+
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+		case <-authCheck.C:
+			permitted, err := app.streamPermitted(r)
+
+			if err != nil {
+				//a failing check leaves the stream open until the next one
+				app.logError(r, err)
+				continue
+			}
+
+			if !permitted {
+				send("event: revoked\ndata: {\"error\": \"the stream is no longer authorised, reconnect with a valid token\"}\n\n")
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-app.changes.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/validator"
+)
+
+// reads the :locale route parameter in its canonical form
+func (app *application) readLocaleParam(r *http.Request) (string, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	return data.NormaliseLocale(params.ByName("locale"))
+}
+
+func (app *application) listMovieTranslationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	translations, err := app.models.Translations.GetAllForMovie(id)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"translations": translations}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// adds or replaces the title and synopsis of the movie in the locale of the path
+func (app *application) putMovieTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Title    string `json:"title"`
+		Synopsis string `json:"synopsis"`
+	}
+
+	err = app.readJSON(w, r, &input)
+
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	locale, _ := app.readLocaleParam(r)
+
+	translation := &data.Translation{
+		Locale:   locale,
+		Title:    input.Title,
+		Synopsis: input.Synopsis,
+	}
+
+	v := validator.New()
+
+	if data.ValidateTranslation(v, translation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	created, err := app.models.Translations.Upsert(id, translation, app.contextGetUser(r).ID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	status := http.StatusOK
+	headers := make(http.Header)
+
+	if created {
+		status = http.StatusCreated
+		headers.Set("Location", fmt.Sprintf("/v1/movies/%d/translations/%s", id, translation.Locale))
+	}
+
+	err = app.writeJSON(w, status, envelope{"translation": translation}, headers)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteMovieTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	locale, ok := app.readLocaleParam(r)
+
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Translations.Delete(id, locale, app.contextGetUser(r).ID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "translation successfully deleted"}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
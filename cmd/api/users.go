@@ -142,6 +142,13 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	//the activation stands even if the event can't be queued
+	err = app.models.Webhooks.Enqueue(data.EventUserActivated, envelope{"user": user})
+
+	if err != nil {
+		app.logger.Error(err.Error(), "user", user.ID)
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 
 	if err != nil {
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/validator"
+)
+
+// lists movies on the current user's watchlist, or those they watched, with the usual movie filters
+func (app *application) listUserMoviesHandler(watched bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			data.MovieSearch
+			data.Filters
+		}
+
+		v := validator.New()
+
+		input.MovieSearch, input.Filters = app.readMovieSearch(r, v)
+
+		if data.ValidateFilters(v, input.Filters); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		user := app.contextGetUser(r)
+
+		if watched {
+			input.WatchedBy = user.ID
+		} else {
+			input.WatchlistOf = user.ID
+		}
+
+		movies, metadata, err := app.models.Movies.GetAll(input.MovieSearch, input.Filters)
+
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.annotateMovies(w, r, movies...)
+
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) addToWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readInt64Param(r, "movie_id")
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Watchlists.Add(app.contextGetUser(r).ID, movieID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie added to watchlist"}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeFromWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readInt64Param(r, "movie_id")
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Watchlists.Remove(app.contextGetUser(r).ID, movieID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie removed from watchlist"}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// body is optional, watched_at defaults to now
+func (app *application) markWatchedHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readInt64Param(r, "movie_id")
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		WatchedAt *time.Time `json:"watched_at"`
+	}
+
+	if r.ContentLength != 0 {
+		err = app.readJSON(w, r, &input)
+
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	watchedAt := time.Now()
+
+	if input.WatchedAt != nil {
+		watchedAt = *input.WatchedAt
+	}
+
+	v := validator.New()
+
+	v.Check(!watchedAt.After(time.Now()), "watched_at", "must not be in the future")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Watchlists.MarkWatched(app.contextGetUser(r).ID, movieID, watchedAt)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie_id": movieID, "watched_at": watchedAt}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unmarkWatchedHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readInt64Param(r, "movie_id")
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Watchlists.UnmarkWatched(app.contextGetUser(r).ID, movieID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie removed from watched"}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"movies.samkha.net/internal/data"
+	"movies.samkha.net/internal/validator"
+)
+
+// bytes of a receiver's response body kept in the delivery log
+const maxLoggedResponseBody = 1024
+
+// deliveries are posted with a timeout well inside the delivery lease and don't follow redirects,
+// a receiver which moved has to be updated instead
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// hex encoded HMAC-SHA256 of the timestamp and body joined by a dot, the timestamp lets receivers reject replays
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// posts the delivery to its webhook and records the outcome, any 2xx response is a success
+func (app *application) attemptDelivery(delivery *data.WebhookDelivery) {
+	attempt := &data.WebhookAttempt{AttemptedAt: time.Now()}
+
+	succeeded := func() bool {
+		req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+
+		if err != nil {
+			attempt.Error = err.Error()
+			return false
+		}
+
+		timestamp := attempt.AttemptedAt.Unix()
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "Greenlight-Webhooks/"+version)
+		req.Header.Set("X-Webhook-Event", delivery.Event)
+		req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.ID, 10))
+		req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+		req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(delivery.Secret, timestamp, delivery.Payload))
+
+		res, err := webhookClient.Do(req)
+
+		if err != nil {
+			attempt.Error = err.Error()
+			return false
+		}
+
+		defer res.Body.Close()
+
+		body, _ := io.ReadAll(io.LimitReader(res.Body, maxLoggedResponseBody))
+
+		attempt.ResponseStatus = &res.StatusCode
+		attempt.ResponseBody = string(bytes.ToValidUTF8(body, nil))
+
+		return res.StatusCode >= 200 && res.StatusCode < 300
+	}()
+
+	attempt.DurationMS = time.Since(attempt.AttemptedAt).Milliseconds()
+
+	err := app.models.Webhooks.RecordAttempt(delivery, attempt, succeeded)
+
+	if err != nil {
+		app.logger.Error(err.Error(), "delivery", delivery.ID)
+		return
+	}
+
+	if delivery.Status == data.DeliveryFailed {
+		app.logger.Info("webhook delivery failed", "delivery", delivery.ID, "webhook", delivery.WebhookID, "attempts", delivery.Attempts)
+	}
+}
+
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+
+	err := app.readJSON(w, r, &input)
+
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	webhook := &data.Webhook{
+		URL:    input.URL,
+		Events: input.Events,
+		Secret: input.Secret,
+	}
+
+	v := validator.New()
+
+	if data.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Insert(webhook)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", webhook.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"webhook": webhook}, headers)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafeList = []string{"id", "url", "created_at", "-id", "-url", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	webhooks, metadata, err := app.models.Webhooks.GetAll(input.Filters)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhooks": webhooks, "metadata": metadata}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	webhook, err := app.models.Webhooks.Get(id)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhook": webhook}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deletes the webhook, its pending deliveries are dropped
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Webhooks.Delete(id)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "webhook successfully deleted"}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deliveries of the webhook, newest first, the status parameter narrows them to pending, succeeded or failed ones
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = "-id"
+	input.Filters.SortSafeList = []string{"-id"}
+
+	v.Check(input.Status == "" || validator.PermittedValue(input.Status, data.DeliveryPending, data.DeliverySucceeded, data.DeliveryFailed), "status", "must be one of pending, succeeded or failed")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Webhooks.Get(id)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	deliveries, metadata, err := app.models.Webhooks.GetDeliveries(id, input.Status, input.Filters)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"deliveries": deliveries, "metadata": metadata}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// the delivery with the log of its attempts
+func (app *application) showWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	deliveryID, err := app.readInt64Param(r, "delivery_id")
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	delivery, err := app.models.Webhooks.GetDelivery(id, deliveryID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"delivery": delivery}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// queues the delivery again, whatever its status, and attempts it right away
+func (app *application) redeliverWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	deliveryID, err := app.readInt64Param(r, "delivery_id")
+
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	delivery, err := app.models.Webhooks.Redeliver(id, deliveryID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	//the attempt records its outcome on its own copy, the response shows the delivery as queued
+	claimed := *delivery
+
+	app.background(func() {
+		app.attemptDelivery(&claimed)
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"delivery": delivery}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,116 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// runs several movie writes in a single transaction
+// savepoints let a failed operation be undone without losing the ones before it
+type MovieBatch struct {
+	tx     *sql.Tx
+	ctx    context.Context
+	cancel context.CancelFunc
+	userID int64
+}
+
+// starts a batch on behalf of userID, the batch must be finished with Commit or Rollback
+// the change log is locked up front, before Get locks any movie, and stays locked until the batch ends
+// so catalog writes of other requests wait for the whole batch
+func (model MovieModel) BeginBatch(userID int64) (*MovieBatch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+	tx, err := model.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	//taken outside the savepoints, a lock taken inside one would be released when its operation is rolled back
+	err = lockChanges(ctx, tx)
+
+	if err != nil {
+		tx.Rollback()
+		cancel()
+		return nil, err
+	}
+
+	return &MovieBatch{tx: tx, ctx: ctx, cancel: cancel, userID: userID}, nil
+}
+
+// returns the movie locked for update until the batch ends
+func (b *MovieBatch) Get(id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id,created_at,title,year,runtime,version,genres,rating,rating_count,poster,status FROM movies WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`
+
+	var movie Movie
+
+	err := b.tx.QueryRowContext(b.ctx, query, id).Scan(&movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, &movie.Version, pq.Array(&movie.Genres), &movie.Rating, &movie.RatingCount, &movie.Poster, &movie.Status)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
+
+func (b *MovieBatch) Insert(movie *Movie) error {
+	return insertMovie(b.ctx, b.tx, movie, b.userID)
+}
+
+// updates the movie if it is still at movie.Version, ErrEditConflict otherwise
+func (b *MovieBatch) Update(movie *Movie) error {
+	return updateMovie(b.ctx, b.tx, movie, b.userID)
+}
+
+// deletes the movie if it is still at version, ErrEditConflict otherwise, 0 deletes whatever the version
+func (b *MovieBatch) Delete(id int64, version int32) error {
+	return deleteMovie(b.ctx, b.tx, id, version, b.userID)
+}
+
+func (b *MovieBatch) Savepoint() error {
+	_, err := b.tx.ExecContext(b.ctx, `SAVEPOINT movie_batch_operation`)
+	return err
+}
+
+func (b *MovieBatch) ReleaseSavepoint() error {
+	_, err := b.tx.ExecContext(b.ctx, `RELEASE SAVEPOINT movie_batch_operation`)
+	return err
+}
+
+// undoes everything done since the last savepoint
+func (b *MovieBatch) RollbackToSavepoint() error {
+	_, err := b.tx.ExecContext(b.ctx, `ROLLBACK TO SAVEPOINT movie_batch_operation`)
+	return err
+}
+
+func (b *MovieBatch) Commit() error {
+	defer b.cancel()
+	return b.tx.Commit()
+}
+
+// no-op once the batch is committed, so it is safe to defer
+func (b *MovieBatch) Rollback() error {
+	defer b.cancel()
+
+	err := b.tx.Rollback()
+
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+
+	return err
+}
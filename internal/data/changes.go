@@ -0,0 +1,176 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// operations recorded in the change log
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// events of the operations, shared by webhooks and the movie stream
+var ChangeEvents = map[string]string{
+	ChangeCreate: EventMovieCreated,
+	ChangeUpdate: EventMovieUpdated,
+	ChangeDelete: EventMovieDeleted,
+}
+
+// state of a movie recorded with its changes, a json object of the columns of the movies row in scope
+const movieChangeState = `jsonb_build_object('id', id, 'title', title, 'year', year, 'runtime', runtime, 'genres', genres, 'status', status, 'version', version)`
+
+// movie as recorded in the change log, only the columns of movieChangeState so nothing which isn't
+// recorded, like ratings which change without a new version, is reported with a zero value
+// keys and runtime formats are those of movie representations
+type MovieState struct {
+	ID            int64         `json:"id"`
+	Title         string        `json:"title"`
+	Year          int32         `json:"year,omitempty"`
+	Runtime       Runtime       `json:"runtime,omitempty"`
+	Genres        []string      `json:",omitempty"`
+	Version       int32         `json:"version"`
+	Status        string        `json:"status"`
+	RuntimeFormat RuntimeFormat `json:"-"`
+}
+
+func (m MovieState) MarshalJSON() ([]byte, error) {
+	type plain MovieState
+
+	if m.RuntimeFormat == RuntimeDefault || m.Runtime == 0 {
+		return json.Marshal(plain(m))
+	}
+
+	return json.Marshal(struct {
+		plain
+		Runtime json.RawMessage `json:"runtime"`
+	}{plain(m), m.Runtime.JSON(m.RuntimeFormat)})
+}
+
+// entry of the append-only log of catalog changes, sequences only ever increase
+type MovieChange struct {
+	Sequence  int64       `json:"sequence"`
+	Operation string      `json:"operation"`
+	MovieID   int64       `json:"movie_id"`
+	Version   int32       `json:"version"`
+	ChangedAt time.Time   `json:"changed_at"`
+	Movie     *MovieState `json:"movie"` // state after the change, nil for deletes
+}
+
+// serialises writers of the change log until the transaction ends, so changes are committed in sequence order
+// and a reader which has seen a sequence never misses a smaller one committed later
+// it has to be taken before any movie row is locked, a writer holding movie row locks while waiting for it could deadlock
+// with a batch holding it while waiting for those rows, readers aren't blocked
+// the cost is that all catalog writes run one at a time, an import (up to 30s) or a batch (up to 10s) holds it
+// for its whole transaction and other edits wait for it, requests timing out meanwhile fail instead of being reordered
+func lockChanges(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `LOCK TABLE movie_changes IN EXCLUSIVE MODE`)
+	return err
+}
+
+// records the current state of the movies as changed by the operation, has to run in the transaction which changed them
+func insertChanges(ctx context.Context, tx *sql.Tx, operation string, ids ...int64) error {
+	query := fmt.Sprintf(`
+	INSERT INTO movie_changes(movie_id,operation,version,movie)
+	SELECT id,$1::text,version,CASE WHEN $1::text = 'delete' THEN NULL ELSE %s END
+	FROM movies WHERE id = ANY($2)
+	ORDER BY id`, movieChangeState)
+
+	_, err := tx.ExecContext(ctx, query, operation, pq.Array(ids))
+
+	return err
+}
+
+type MovieChangeModel struct {
+	DB *sql.DB
+}
+
+// sequence of the last recorded change, 0 when nothing has been recorded
+func (m MovieChangeModel) Latest() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var sequence int64
+
+	err := m.DB.QueryRowContext(ctx, `SELECT COALESCE(max(sequence), 0) FROM movie_changes`).Scan(&sequence)
+
+	return sequence, err
+}
+
+// returns up to limit changes recorded after the since sequence, oldest first
+func (m MovieChangeModel) GetSince(since int64, limit int) ([]*MovieChange, error) {
+	query := `
+	SELECT sequence,operation,movie_id,version,changed_at,movie
+	FROM movie_changes WHERE sequence > $1
+	ORDER BY sequence
+	LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, since, limit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return scanChanges(rows)
+}
+
+// scans rows of the columns GetSince selects
+func scanChanges(rows *sql.Rows) ([]*MovieChange, error) {
+	changes := []*MovieChange{}
+
+	for rows.Next() {
+		var (
+			change MovieChange
+			state  []byte
+		)
+
+		err := rows.Scan(&change.Sequence, &change.Operation, &change.MovieID, &change.Version, &change.ChangedAt, &state)
+
+		if err != nil {
+			return nil, err
+		}
+
+		change.Movie, err = decodeMovieState(state)
+
+		if err != nil {
+			return nil, fmt.Errorf("change %d: %w", change.Sequence, err)
+		}
+
+		changes = append(changes, &change)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// decodes the movie column of a change, nil for deletes
+func decodeMovieState(state []byte) (*MovieState, error) {
+	if state == nil {
+		return nil, nil
+	}
+
+	var movie MovieState
+
+	err := json.Unmarshal(state, &movie)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &movie, nil
+}
@@ -0,0 +1,71 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMovieChangeJSON(t *testing.T) {
+	changedAt := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		operation string
+		state     string // movie column as written by movieChangeState, empty for deletes
+		format    RuntimeFormat
+		want      string
+	}{
+		{
+			//the movie is rated but the snapshot has no ratings, which must not be reported as zero
+			name:      "rated movie",
+			operation: ChangeUpdate,
+			state:     `{"id": 7, "year": 1994, "title": "Pulp Fiction", "genres": ["crime", "drama"], "status": "released", "runtime": 154, "version": 3}`,
+			want:      `{"sequence":12,"operation":"update","movie_id":7,"version":3,"changed_at":"2024-03-01T12:00:00Z","movie":{"id":7,"title":"Pulp Fiction","year":1994,"runtime":"154 mins","Genres":["crime","drama"],"version":3,"status":"released"}}`,
+		},
+		{
+			name:      "runtime format",
+			operation: ChangeUpdate,
+			state:     `{"id": 7, "year": 1994, "title": "Pulp Fiction", "genres": [], "status": "released", "runtime": 154, "version": 3}`,
+			format:    RuntimeISO8601,
+			want:      `{"sequence":12,"operation":"update","movie_id":7,"version":3,"changed_at":"2024-03-01T12:00:00Z","movie":{"id":7,"title":"Pulp Fiction","year":1994,"version":3,"status":"released","runtime":"PT2H34M"}}`,
+		},
+		{
+			name:      "delete",
+			operation: ChangeDelete,
+			want:      `{"sequence":12,"operation":"delete","movie_id":7,"version":3,"changed_at":"2024-03-01T12:00:00Z","movie":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state []byte
+
+			if tt.state != "" {
+				state = []byte(tt.state)
+			}
+
+			movie, err := decodeMovieState(state)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if movie != nil {
+				movie.RuntimeFormat = tt.format
+			}
+
+			change := MovieChange{Sequence: 12, Operation: tt.operation, MovieID: 7, Version: 3, ChangedAt: changedAt, Movie: movie}
+
+			js, err := json.Marshal(change)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(js) != tt.want {
+				t.Errorf("got  %s\nwant %s", js, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,370 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+	"movies.samkha.net/internal/validator"
+)
+
+var (
+	ErrEntriesMismatch = errors.New("entries mismatch")
+)
+
+type Collection struct {
+	ID          int64              `json:"id"`
+	UserID      int64              `json:"user_id"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+	Title       string             `json:"title"`
+	Description string             `json:"description,omitempty"`
+	Public      bool               `json:"public"`
+	Version     int32              `json:"version"`
+	Entries     []*CollectionEntry `json:"entries,omitempty"` // only loaded when showing a single collection
+}
+
+type CollectionEntry struct {
+	Position int32     `json:"position"`
+	Note     string    `json:"note,omitempty"`
+	AddedAt  time.Time `json:"added_at"`
+	Movie    *Movie    `json:"movie"`
+}
+
+// reports whether the user is allowed to see the collection
+func (c *Collection) VisibleTo(user *User) bool {
+	return c.Public || (!user.IsAnonymous() && c.UserID == user.ID)
+}
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(validator.NotBlank(collection.Title), "title", "must be provided")
+	v.Check(validator.MaxChars(collection.Title, 200), "title", "must not be more than 200 characters long")
+	v.Check(validator.PrintableText(collection.Title), "title", "must not contain control characters")
+	v.Check(validator.MaxChars(collection.Description, 5000), "description", "must not be more than 5000 characters long")
+	v.Check(validator.PrintableText(collection.Description), "description", "must not contain control characters")
+}
+
+func ValidateCollectionNote(v *validator.Validator, note string) {
+	v.Check(validator.MaxChars(note, 2000), "note", "must not be more than 2000 characters long")
+	v.Check(validator.PrintableText(note), "note", "must not contain control characters")
+}
+
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+// public collections, or all collections of ownerID when it is set
+func (m CollectionModel) GetAll(ownerID int64, filters Filters) ([]*Collection, MetaData, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id,user_id,created_at,updated_at,title,description,public,version
+	FROM collections WHERE (user_id=$1 OR ($1=0 AND public))
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3
+	`, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, ownerID, filters.limit(), filters.offset())
+
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	collections := []*Collection{}
+
+	for rows.Next() {
+		var collection Collection
+
+		err := rows.Scan(&totalRecords, &collection.ID, &collection.UserID, &collection.CreatedAt, &collection.UpdatedAt, &collection.Title, &collection.Description, &collection.Public, &collection.Version)
+
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		collections = append(collections, &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return collections, metadata, nil
+}
+
+func (m CollectionModel) Insert(collection *Collection) error {
+	query := `INSERT INTO collections(user_id,title,description,public) VALUES($1,$2,$3,$4) RETURNING id,created_at,updated_at,version`
+	args := []any{collection.UserID, collection.Title, collection.Description, collection.Public}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.ID, &collection.CreatedAt, &collection.UpdatedAt, &collection.Version)
+}
+
+func (m CollectionModel) Get(id int64) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id,user_id,created_at,updated_at,title,description,public,version FROM collections WHERE id=$1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var collection Collection
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&collection.ID, &collection.UserID, &collection.CreatedAt, &collection.UpdatedAt, &collection.Title, &collection.Description, &collection.Public, &collection.Version)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &collection, nil
+}
+
+func (m CollectionModel) Update(collection *Collection) error {
+	query := `UPDATE collections SET title=$1,description=$2,public=$3,updated_at=NOW(),version=version+1 WHERE id=$4 AND version=$5 RETURNING updated_at,version`
+	args := []any{collection.Title, collection.Description, collection.Public, collection.ID, collection.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.UpdatedAt, &collection.Version)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m CollectionModel) Delete(id int64) error {
+	query := `DELETE FROM collections WHERE id=$1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// entries in collection order, movies in the trash are left out
+func (m CollectionModel) GetEntries(collectionID int64) ([]*CollectionEntry, error) {
+	query := `
+	SELECT collection_entries.position, collection_entries.note, collection_entries.added_at,
+	movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version, movies.rating, movies.rating_count, movies.poster, movies.status
+	FROM collection_entries INNER JOIN movies ON movies.id = collection_entries.movie_id
+	WHERE collection_entries.collection_id = $1 AND movies.deleted_at IS NULL
+	ORDER BY collection_entries.position`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, collectionID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	entries := []*CollectionEntry{}
+
+	for rows.Next() {
+		var entry CollectionEntry
+		var movie Movie
+
+		err := rows.Scan(&entry.Position, &entry.Note, &entry.AddedAt, &movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version, &movie.Rating, &movie.RatingCount, &movie.Poster, &movie.Status)
+
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Movie = &movie
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// adds the movie at the end of the collection, or replaces the note if it already is in the collection
+func (m CollectionModel) UpsertEntry(collectionID, movieID int64, note string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := movieExists(ctx, m.DB, movieID)
+
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	//lock the collection so concurrent additions can't both take the position after the last entry
+	_, err = tx.ExecContext(ctx, `SELECT id FROM collections WHERE id=$1 FOR UPDATE`, collectionID)
+
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO collection_entries(collection_id,movie_id,note,position)
+	SELECT $1, $2, $3, COALESCE(MAX(position), 0) + 1 FROM collection_entries WHERE collection_id=$1
+	ON CONFLICT (collection_id,movie_id) DO UPDATE SET note=EXCLUDED.note`
+
+	_, err = tx.ExecContext(ctx, query, collectionID, movieID, note)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE collections SET updated_at=NOW() WHERE id=$1`, collectionID)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m CollectionModel) DeleteEntry(collectionID, movieID int64) error {
+	query := `DELETE FROM collection_entries WHERE collection_id=$1 AND movie_id=$2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, collectionID, movieID)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = m.DB.ExecContext(ctx, `UPDATE collections SET updated_at=NOW() WHERE id=$1`, collectionID)
+
+	return err
+}
+
+// puts the entries in the order of movieIDs, which must list every visible movie of the collection exactly once
+// entries of movies in the trash move behind them
+func (m CollectionModel) Reorder(collectionID int64, movieIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	//lock the collection so concurrent additions can't slip in between the check and the update
+	_, err = tx.ExecContext(ctx, `SELECT id FROM collections WHERE id=$1 FOR UPDATE`, collectionID)
+
+	if err != nil {
+		return err
+	}
+
+	var current []int64
+
+	//entries of trashed movies aren't shown, so they aren't expected to be listed either
+	query := `
+	SELECT COALESCE(array_agg(collection_entries.movie_id ORDER BY collection_entries.movie_id), '{}')
+	FROM collection_entries INNER JOIN movies ON movies.id = collection_entries.movie_id
+	WHERE collection_entries.collection_id=$1 AND movies.deleted_at IS NULL`
+
+	err = tx.QueryRowContext(ctx, query, collectionID).Scan(pq.Array(&current))
+
+	if err != nil {
+		return err
+	}
+
+	requested := slices.Clone(movieIDs)
+	slices.Sort(requested)
+
+	if !slices.Equal(current, requested) {
+		return ErrEntriesMismatch
+	}
+
+	//every entry is renumbered, those of trashed movies after the listed ones in their previous order,
+	//so their positions don't collide with the new ones once the movies are restored
+	query = `
+	UPDATE collection_entries SET position = renumbered.position
+	FROM (
+		SELECT collection_entries.movie_id,
+		row_number() OVER (ORDER BY requested.position NULLS LAST, collection_entries.position, collection_entries.movie_id) AS position
+		FROM collection_entries
+		LEFT JOIN unnest($2::bigint[]) WITH ORDINALITY AS requested(movie_id, position) ON requested.movie_id = collection_entries.movie_id
+		WHERE collection_entries.collection_id = $1
+	) AS renumbered
+	WHERE collection_entries.collection_id = $1 AND collection_entries.movie_id = renumbered.movie_id`
+
+	_, err = tx.ExecContext(ctx, query, collectionID, pq.Array(movieIDs))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE collections SET updated_at=NOW() WHERE id=$1`, collectionID)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,74 @@
+package data
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestReorderThenRestore(t *testing.T) {
+	db := openTestDB(t)
+	models := NewModels(db)
+	userID := insertTestUser(t, db)
+
+	var ids []int64
+
+	for _, title := range []string{"First", "Second", "Third"} {
+		movie := &Movie{Title: title, Year: 2000, Runtime: 100, Genres: []string{"drama"}, Status: "released"}
+
+		if err := models.Movies.Insert(movie, userID); err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, movie.ID)
+
+		t.Cleanup(func() { db.Exec(`DELETE FROM movies WHERE id=$1`, movie.ID) })
+	}
+
+	collection := &Collection{UserID: userID, Title: "Reorder"}
+
+	if err := models.Collections.Insert(collection); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range ids {
+		if err := models.Collections.UpsertEntry(collection.ID, id, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//the second movie is trashed, so it's left out of the reorder
+	if err := models.Movies.Delete(ids[1], 0, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := models.Collections.Reorder(collection.ID, []int64{ids[2], ids[0]}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := models.Movies.Restore(ids[1], userID); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := models.Collections.GetEntries(collection.ID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var order []int64
+	var positions []int32
+
+	for _, entry := range entries {
+		order = append(order, entry.Movie.ID)
+		positions = append(positions, entry.Position)
+	}
+
+	//the restored movie follows the reordered ones instead of sharing a position with one of them
+	if want := []int64{ids[2], ids[0], ids[1]}; !slices.Equal(order, want) {
+		t.Errorf("movies %v, want %v", order, want)
+	}
+
+	if want := []int32{1, 2, 3}; !slices.Equal(positions, want) {
+		t.Errorf("positions %v, want %v", positions, want)
+	}
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// connects to the database of GREENLIGHT_TEST_DATABASE_DSN, which must have all migrations applied
+// tests using it are skipped when it isn't set, they clean up the rows they create but shouldn't share a production database
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("GREENLIGHT_TEST_DATABASE_DSN")
+
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err = db.PingContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// inserts a user which is deleted along with everything it owns when the test ends
+func insertTestUser(t *testing.T, db *sql.DB) int64 {
+	t.Helper()
+
+	var id int64
+
+	query := `INSERT INTO users(name,email,password_hash,activated) VALUES('Test',$1,'\x00',true) RETURNING id`
+
+	err := db.QueryRow(query, fmt.Sprintf("test-%d@example.com", time.Now().UnixNano())).Scan(&id)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Exec(`DELETE FROM users WHERE id=$1`, id) })
+
+	return id
+}
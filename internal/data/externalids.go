@@ -0,0 +1,174 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"movies.samkha.net/internal/validator"
+)
+
+var (
+	ErrDuplicateExternalID = errors.New("duplicate external id")
+
+	ExternalSourceRx = regexp.MustCompile(`^[a-z][a-z0-9_]{1,31}$`)
+)
+
+// formats of the identifiers of well known sources, identifiers of other sources are only checked for sanity
+var externalIDFormats = map[string]struct {
+	rx      *regexp.Regexp
+	example string
+}{
+	"imdb":     {regexp.MustCompile(`^tt[0-9]{7,}$`), "tt0111161"},
+	"tmdb":     {regexp.MustCompile(`^[1-9][0-9]*$`), "278"},
+	"wikidata": {regexp.MustCompile(`^Q[1-9][0-9]*$`), "Q172241"},
+}
+
+// identifiers of a movie in other databases by source, e.g. {"imdb": "tt0111161"}
+type ExternalIDs map[string]string
+
+// formats the identifiers as comma separated source:id pairs ordered by source, the way csv imports read them
+func (ids ExternalIDs) String() string {
+	pairs := make([]string, 0, len(ids))
+
+	for source, id := range ids {
+		pairs = append(pairs, source+":"+id)
+	}
+
+	slices.Sort(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+// splits an identifier written as "source:id"
+func ParseExternalID(s string) (source, id string, ok bool) {
+	source, id, ok = strings.Cut(strings.TrimSpace(s), ":")
+
+	return strings.ToLower(source), id, ok && source != "" && id != ""
+}
+
+func ValidateExternalIDs(v *validator.Validator, key string, ids ExternalIDs) {
+	v.Check(len(ids) <= 10, key, "must not contain more than 10 identifiers")
+
+	for source, id := range ids {
+		if !validator.Matches(source, ExternalSourceRx) {
+			v.AddError(key, fmt.Sprintf("source %q must be lower case letters, digits or underscores", source))
+			continue
+		}
+
+		if format, ok := externalIDFormats[source]; ok && !validator.Matches(id, format.rx) {
+			v.AddError(key, fmt.Sprintf("%s id %q must look like %s", source, id, format.example))
+			continue
+		}
+
+		v.Check(id != "" && len(id) <= 100, key, fmt.Sprintf("%s id must be between 1 and 100 bytes long", source))
+		v.Check(!strings.ContainsFunc(id, func(r rune) bool { return unicode.IsSpace(r) || !unicode.IsPrint(r) }), key, fmt.Sprintf("%s id must not contain spaces or control characters", source))
+	}
+}
+
+// movies which are likely to be the same, as their titles are equal once normalised and they are from the same year
+type DuplicateGroup struct {
+	Title  string   `json:"title"` // normalised title shared by the movies
+	Year   int32    `json:"year"`
+	Movies []*Movie `json:"movies"`
+}
+
+type ExternalIDModel struct {
+	DB *sql.DB
+}
+
+func (m ExternalIDModel) GetForMovie(movieID int64) (ExternalIDs, error) {
+	query := `SELECT source,external_id FROM external_ids WHERE movie_id=$1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ids := ExternalIDs{}
+
+	for rows.Next() {
+		var source, id string
+
+		err := rows.Scan(&source, &id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ids[source] = id
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// replaces all identifiers of the movie and bumps the movie version so cached representations are invalidated
+// movie.Version must be the version the client has seen, ErrEditConflict otherwise
+// ErrDuplicateExternalID is returned when an identifier already belongs to another movie
+func (m ExternalIDModel) Replace(movie *Movie, ids ExternalIDs, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = bumpMovieVersion(ctx, tx, movie, userID)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM external_ids WHERE movie_id=$1`, movie.ID)
+
+	if err != nil {
+		return err
+	}
+
+	err = insertExternalIDs(ctx, tx, movie.ID, ids)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertExternalIDs(ctx context.Context, tx *sql.Tx, movieID int64, ids ExternalIDs) error {
+	query := `INSERT INTO external_ids(source,external_id,movie_id) VALUES($1,$2,$3)`
+
+	for source, id := range ids {
+		_, err := tx.ExecContext(ctx, query, source, id, movieID)
+
+		if err != nil {
+			switch {
+			case isUniqueViolation(err):
+				return ErrDuplicateExternalID
+			default:
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,323 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+	"movies.samkha.net/internal/validator"
+)
+
+var (
+	ErrDuplicateGenre = errors.New("duplicate genre")
+
+	GenreSlugRx = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+)
+
+type Genre struct {
+	Slug       string   `json:"slug"`
+	Name       string   `json:"name"`
+	Aliases    []string `json:"aliases"`
+	MovieCount int64    `json:"movie_count"`
+}
+
+// lower cases the genre and joins its words, separated by whitespace or underscores, with hyphens
+// must be kept in line with the canonical_genre sql function, last defined in migration 000028
+func genreKey(genre string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(genre), func(r rune) bool {
+		return unicode.IsSpace(r) || r == '_'
+	}), "-")
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(validator.NotBlank(genre.Name), "name", "must be provided")
+	v.Check(validator.MaxChars(genre.Name, 50), "name", "must not be more than 50 characters long")
+
+	//clients only send the name and the slug is derived from it by GenreSlug, so a bad slug is a problem with the name
+	//aliases are put in slug form the same way, so theirs are reported in the spelling clients send too
+	v.Check(GenreSlugRx.MatchString(genre.Slug), "name", "must only contain letters, digits, spaces and hyphens")
+	v.Check(validator.Unique(genre.Aliases), "aliases", "must not contain duplicate values")
+
+	for _, alias := range genre.Aliases {
+		v.Check(GenreSlugRx.MatchString(alias), "aliases", "must only contain letters, digits, spaces and hyphens")
+		v.Check(alias != genre.Slug, "aliases", "must not contain the slug")
+	}
+}
+
+// the slug of a genre is derived from its name, so looking genres up by name works the same in go and sql
+func GenreSlug(name string) string {
+	return genreKey(name)
+}
+
+// known genres and their aliases, used to resolve the genres of movies to their slugs
+type GenreTaxonomy struct {
+	slugs   []string
+	lookup  map[string]string // slugs, names and aliases by their key
+	aliases map[string]string
+}
+
+func NewGenreTaxonomy(genres []*Genre) *GenreTaxonomy {
+	t := &GenreTaxonomy{lookup: make(map[string]string), aliases: make(map[string]string)}
+
+	for _, genre := range genres {
+		t.slugs = append(t.slugs, genre.Slug)
+		t.lookup[genre.Slug] = genre.Slug
+		t.lookup[genreKey(genre.Name)] = genre.Slug
+
+		for _, alias := range genre.Aliases {
+			t.lookup[alias] = genre.Slug
+			t.aliases[alias] = genre.Slug
+		}
+	}
+
+	return t
+}
+
+// resolves a genre given by slug, display name or alias to its slug
+func (t *GenreTaxonomy) Canonical(genre string) (string, bool) {
+	slug, ok := t.lookup[genreKey(genre)]
+	return slug, ok
+}
+
+// slugs close to the unknown genre, best match first
+func (t *GenreTaxonomy) Suggest(genre string) []string {
+	key := genreKey(genre)
+
+	if key == "" {
+		return nil
+	}
+
+	distances := make(map[string]int)
+
+	consider := func(candidate, slug string) {
+		d := levenshtein(key, candidate)
+
+		//prefixes and parts of longer names count as close, "fiction" for "science-fiction"
+		if strings.HasPrefix(candidate, key) || slices.Contains(strings.Split(candidate, "-"), key) {
+			d = min(d, 1)
+		}
+
+		if d > max(2, len(key)/3) {
+			return
+		}
+
+		if current, ok := distances[slug]; !ok || d < current {
+			distances[slug] = d
+		}
+	}
+
+	for _, slug := range t.slugs {
+		consider(slug, slug)
+	}
+
+	for alias, slug := range t.aliases {
+		consider(alias, slug)
+	}
+
+	suggestions := make([]string, 0, len(distances))
+
+	for slug := range distances {
+		suggestions = append(suggestions, slug)
+	}
+
+	slices.SortFunc(suggestions, func(a, b string) int {
+		if distances[a] != distances[b] {
+			return distances[a] - distances[b]
+		}
+
+		return strings.Compare(a, b)
+	})
+
+	if len(suggestions) > 3 {
+		suggestions = suggestions[:3]
+	}
+
+	return suggestions
+}
+
+// replaces the genres of the movie with their slugs, unknown genres are reported with suggestions
+func (t *GenreTaxonomy) validate(v *validator.Validator, movie *Movie) {
+	for i, genre := range movie.Genres {
+		slug, ok := t.Canonical(genre)
+
+		if ok {
+			movie.Genres[i] = slug
+			continue
+		}
+
+		message := fmt.Sprintf("unknown genre %q", genre)
+
+		if suggestions := t.Suggest(genre); len(suggestions) > 0 {
+			message += fmt.Sprintf(", did you mean %s?", strings.Join(suggestions, ", "))
+		}
+
+		v.AddError("genres", message)
+	}
+}
+
+// edit distance between a and b in runes
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
+
+type GenreModel struct {
+	DB *sql.DB
+}
+
+// every genre with its aliases and the number of movies outside the trash in it
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `
+	SELECT genres.slug, genres.name,
+	ARRAY(SELECT alias FROM genre_aliases WHERE genre_slug = genres.slug ORDER BY alias),
+	(SELECT count(*) FROM movies WHERE movies.genres @> ARRAY[genres.slug] AND movies.deleted_at IS NULL)
+	FROM genres
+	ORDER BY genres.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(&genre.Slug, &genre.Name, pq.Array(&genre.Aliases), &genre.MovieCount)
+
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// loads the taxonomy movies are validated against
+func (m GenreModel) Taxonomy() (*GenreTaxonomy, error) {
+	query := `
+	SELECT genres.slug, genres.name, ARRAY(SELECT alias FROM genre_aliases WHERE genre_slug = genres.slug)
+	FROM genres`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var genres []*Genre
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(&genre.Slug, &genre.Name, pq.Array(&genre.Aliases))
+
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewGenreTaxonomy(genres), nil
+}
+
+// adds the genre along with its aliases, slugs, names and aliases already in use fail with ErrDuplicateGenre
+func (m GenreModel) Insert(genre *Genre) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	//slugs and aliases share one namespace, otherwise a genre could resolve to two slugs
+	var taken bool
+
+	query := `
+	SELECT EXISTS(SELECT 1 FROM genre_aliases WHERE alias = $1 OR alias = ANY($2))
+	OR EXISTS(SELECT 1 FROM genres WHERE slug = ANY($2))`
+
+	err = tx.QueryRowContext(ctx, query, genre.Slug, pq.Array(genre.Aliases)).Scan(&taken)
+
+	if err != nil {
+		return err
+	}
+
+	if taken {
+		return ErrDuplicateGenre
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO genres(slug, name) VALUES($1, $2)`, genre.Slug, genre.Name)
+
+	if err == nil {
+		_, err = tx.ExecContext(ctx, `INSERT INTO genre_aliases(alias, genre_slug) SELECT unnest($1::text[]), $2`, pq.Array(genre.Aliases), genre.Slug)
+	}
+
+	if err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return ErrDuplicateGenre
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
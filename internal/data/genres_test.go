@@ -0,0 +1,52 @@
+package data
+
+import "testing"
+
+// inputs and their keys, none of them is an alias so canonical_genre returns the key as well
+var genreKeyTests = []struct {
+	input string
+	want  string
+}{
+	{"action", "action"},
+	{"Action", "action"},
+	{" Science Fiction ", "science-fiction"},
+	{"science_fiction", "science-fiction"},
+	{"Film  Noir", "film-noir"},
+	{"film _ noir", "film-noir"},
+	{"_action_", "action"},
+	{"__action", "action"},
+	{"\tdrama\n", "drama"},
+	{"_ drama _", "drama"},
+	{"-action-", "-action-"},
+	{"sci - fi", "sci---fi"},
+	{"__", ""},
+	{" ", ""},
+	{"", ""},
+}
+
+func TestGenreKey(t *testing.T) {
+	for _, tt := range genreKeyTests {
+		if got := genreKey(tt.input); got != tt.want {
+			t.Errorf("genreKey(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+// the sql function normalises genres stored and searched in the database, it must agree with genreKey
+func TestCanonicalGenre(t *testing.T) {
+	db := openTestDB(t)
+
+	for _, tt := range genreKeyTests {
+		var got string
+
+		err := db.QueryRow(`SELECT canonical_genre($1)`, tt.input).Scan(&got)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.want {
+			t.Errorf("canonical_genre(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
@@ -11,17 +11,45 @@ var (
 )
 
 type Models struct {
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Movies          MovieModel
+	Genres          GenreModel
+	Revisions       MovieRevisionModel
+	Changes         MovieChangeModel
+	Translations    TranslationModel
+	ExternalIDs     ExternalIDModel
+	Releases        ReleaseModel
+	Stats           StatsModel
+	People          PersonModel
+	Ratings         RatingModel
+	Reviews         ReviewModel
+	Watchlists      WatchlistModel
+	Collections     CollectionModel
+	Recommendations RecommendationModel
+	Users           UserModel
+	Tokens          TokenModel
+	Permissions     PermissionModel
+	Webhooks        WebhookModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
+		Movies:          MovieModel{DB: db},
+		Genres:          GenreModel{DB: db},
+		Revisions:       MovieRevisionModel{DB: db},
+		Changes:         MovieChangeModel{DB: db},
+		Translations:    TranslationModel{DB: db},
+		ExternalIDs:     ExternalIDModel{DB: db},
+		Releases:        ReleaseModel{DB: db},
+		Stats:           StatsModel{DB: db},
+		People:          PersonModel{DB: db},
+		Ratings:         RatingModel{DB: db},
+		Reviews:         ReviewModel{DB: db},
+		Watchlists:      WatchlistModel{DB: db},
+		Collections:     CollectionModel{DB: db},
+		Recommendations: RecommendationModel{DB: db},
+		Users:           UserModel{DB: db},
+		Tokens:          TokenModel{DB: db},
+		Permissions:     PermissionModel{DB: db},
+		Webhooks:        WebhookModel{DB: db},
 	}
 }
@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -12,22 +13,98 @@ import (
 )
 
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"` // - directive omits the item from json
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`    // - omitempty omits the item if empty/falsy value
-	Runtime   Runtime   `json:"runtime,omitempty"` // - string directive changes the field item to string
-	Genres    []string  `json:",omitempty"`        // leaving 1st directive blank leave the filed title as it is
-	Version   int32     `json:"version"`
+	ID            int64         `json:"id"`
+	CreatedAt     time.Time     `json:"-"` // - directive omits the item from json
+	Title         string        `json:"title"`
+	Year          int32         `json:"year,omitempty"`    // - omitempty omits the item if empty/falsy value
+	Runtime       Runtime       `json:"runtime,omitempty"` // - string directive changes the field item to string
+	Genres        []string      `json:",omitempty"`        // leaving 1st directive blank leave the filed title as it is
+	Version       int32         `json:"version"`
+	Status        string        `json:"status"`                   // released or upcoming
+	DeletedAt     *time.Time    `json:"deleted_at,omitempty"`     // nil unless movie is in the trash
+	Credits       []*Credit     `json:"credits,omitempty"`        // only loaded when showing a single movie
+	ExternalIDs   ExternalIDs   `json:"external_ids,omitempty"`   // only loaded when showing a single movie and when exporting
+	Releases      []*Release    `json:"releases,omitempty"`       // only loaded when showing a single movie
+	Rating        float64       `json:"rating"`                   // average of user ratings, 0 when unrated
+	RatingCount   int64         `json:"rating_count"`             // number of users who rated the movie
+	InWatchlist   *bool         `json:"in_watchlist,omitempty"`   // only set for authenticated users
+	WatchedAt     *time.Time    `json:"watched_at,omitempty"`     // only set for authenticated users who watched the movie
+	Score         float64       `json:"score,omitempty"`          // relevance, only set when ranking movies against something
+	Poster        ImageURLs     `json:"poster,omitempty"`         // urls of the poster by size, nil without poster
+	OriginalTitle string        `json:"original_title,omitempty"` // only set when the title is shown in another language
+	Synopsis      string        `json:"synopsis,omitempty"`       // in the language of the shown title, movies have no synopsis of their own
+	Locale        string        `json:"locale,omitempty"`         // language of the shown title, empty for the original
+	RuntimeFormat RuntimeFormat `json:"-"`                        // how the runtime is written in json, "<n> mins" by default
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+func (m Movie) MarshalJSON() ([]byte, error) {
+	//the defined type has none of the methods of Movie, so marshalling it doesn't recurse
+	type plain Movie
+
+	if m.RuntimeFormat == RuntimeDefault || m.Runtime == 0 {
+		return json.Marshal(plain(m))
+	}
+
+	//the runtime field is shallower than the one of the embedded movie, so it replaces it
+	return json.Marshal(struct {
+		plain
+		Runtime json.RawMessage `json:"runtime"`
+	}{plain(m), m.Runtime.JSON(m.RuntimeFormat)})
+}
+
+// criteria used to search movies, shared by listing and export
+type MovieSearch struct {
+	Title          string
+	Genres         []string
+	PersonID       int64
+	WatchlistOf    int64  // user whose watchlist the movies must be on
+	WatchedBy      int64  // user who must have watched the movies
+	SearchConfig   string // text search configuration used to match translated titles, 'simple' when empty
+	ExternalSource string // source of ExternalID, both are empty unless looking a movie up by its external id
+	ExternalID     string
+	ReleasedIn     string // country the movies must have been released in by today
+	MaxAge         *int   // highest minimum age of a certification, in ReleasedIn if set
+	Status         string
+}
+
+// returns the where clause for the search and its arguments, which take placeholders $1 to $11
+// the original title is always matched as is, translated titles are matched with the configuration of the searcher's language
+func (s MovieSearch) clause() (string, []any) {
+	config := s.SearchConfig
+
+	if config == "" {
+		config = "simple"
+	}
+
+	clause := `deleted_at IS NULL
+	AND (to_tsvector('simple',title) @@ plainto_tsquery('simple', $1) OR $1=''
+		OR id IN (SELECT movie_id FROM movie_translations WHERE search_config=$6::regconfig AND search @@ plainto_tsquery($6::regconfig, $1)))
+	AND (genres @> ARRAY(SELECT canonical_genre(genre) FROM unnest($2::text[]) AS genre) OR $2='{}')
+	AND (id IN (SELECT movie_id FROM movie_credits WHERE person_id=$3) OR $3=0)
+	AND (id IN (SELECT movie_id FROM watchlist WHERE user_id=$4) OR $4=0)
+	AND (id IN (SELECT movie_id FROM watched WHERE user_id=$5) OR $5=0)
+	AND (id IN (SELECT movie_id FROM external_ids WHERE source=$7 AND external_id=$8) OR $7='')
+	AND (id IN (SELECT movie_id FROM movie_releases WHERE country=$9 AND release_date <= CURRENT_DATE) OR $9='')
+	AND (id IN (SELECT movie_id FROM movie_releases WHERE minimum_age <= $10 AND (country=$9 OR $9='')) OR $10::int IS NULL)
+	AND (status=$11 OR $11='')`
+
+	return clause, []any{s.Title, pq.Array(s.Genres), s.PersonID, s.WatchlistOf, s.WatchedBy, config, s.ExternalSource, s.ExternalID, s.ReleasedIn, s.MaxAge, s.Status}
+}
+
+// genres given by name or alias are replaced by their slug, unknown genres fail with suggestions
+func ValidateMovie(v *validator.Validator, movie *Movie, genres *GenreTaxonomy) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
 
 	v.Check(movie.Year != 0, "year", "must be provided")
 	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
-	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
+	if movie.Status == "" {
+		movie.Status = MovieReleased
+	}
+
+	v.Check(validator.PermittedValue(movie.Status, MovieReleased, MovieUpcoming), "status", "must be released or upcoming")
+	v.Check(movie.Year <= int32(time.Now().Year()) || movie.Status == MovieUpcoming, "year", "must not be in the future unless the movie is upcoming")
+	v.Check(movie.Year <= int32(time.Now().Year())+10, "year", "must not be more than 10 years in the future")
 
 	v.Check(movie.Runtime != 0, "runtime", "must be provided")
 	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
@@ -35,27 +112,31 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Genres != nil, "genres", "must be provided")
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
+	genres.validate(v, movie)
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+
+	ValidateExternalIDs(v, "external_ids", movie.ExternalIDs)
 }
 
 type MovieModel struct {
 	DB *sql.DB
 }
 
-func (model MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, MetaData, error) {
+func (model MovieModel) GetAll(search MovieSearch, filters Filters) ([]*Movie, MetaData, error) {
+	where, args := search.clause()
+
 	//the count(*) OVER() is used for filtered record count
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id,created_at,title,year,runtime,genres,version
-	FROM movies WHERE (to_tsvector('simple',title) @@ plainto_tsquery('simple', $1) OR $1='') 
-	AND (genres @> $2 OR $2='{}')
+	SELECT count(*) OVER(), id,created_at,title,year,runtime,genres,version,rating,rating_count,poster,status
+	FROM movies WHERE %s
 	ORDER BY %s %s, id ASC
-	LIMIT $3 OFFSET $4
-	`, filters.SortColumn(), filters.SortDirection())
+	LIMIT $12 OFFSET $13
+	`, where, filters.SortColumn(), filters.SortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
+	args = append(args, filters.limit(), filters.offset())
 
 	rows, err := model.DB.QueryContext(ctx, query, args...)
 
@@ -71,7 +152,105 @@ func (model MovieModel) GetAll(title string, genres []string, filters Filters) (
 	for rows.Next() {
 		var movie Movie
 
-		err := rows.Scan(&totalRecords, &movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version)
+		err := rows.Scan(&totalRecords, &movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version, &movie.Rating, &movie.RatingCount, &movie.Poster, &movie.Status)
+
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// returns the movies with the given ids in the same order as ids, missing ids are skipped
+func (model MovieModel) GetByIDs(ids []int64) ([]*Movie, error) {
+	query := `
+	SELECT id,created_at,title,year,runtime,genres,version,rating,rating_count,poster,status
+	FROM movies WHERE id = ANY($1) AND deleted_at IS NULL
+	ORDER BY array_position($1::bigint[], id)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := model.DB.QueryContext(ctx, query, pq.Array(ids))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(&movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version, &movie.Rating, &movie.RatingCount, &movie.Poster, &movie.Status)
+
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// movies ranked by how similar they are to the movie with the given id
+// genre overlap weighs the most, then users who liked both movies, then title similarity and year proximity
+func (model MovieModel) GetSimilar(id int64, filters Filters) ([]*Movie, MetaData, error) {
+	query := `
+	WITH source AS (
+		SELECT id, title, year, genres FROM movies WHERE id = $1 AND deleted_at IS NULL
+	), co_ratings AS (
+		SELECT other.movie_id, count(*) AS raters
+		FROM ratings liked INNER JOIN ratings other ON other.user_id = liked.user_id AND other.movie_id <> liked.movie_id
+		WHERE liked.movie_id = $1 AND liked.rating >= 7 AND other.rating >= 7
+		GROUP BY other.movie_id
+	), scored AS (
+		SELECT movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version, movies.rating, movies.rating_count, movies.poster, movies.status,
+		3 * COALESCE(cardinality(ARRAY(SELECT unnest(movies.genres) INTERSECT SELECT unnest(source.genres)))::float8
+			/ NULLIF(cardinality(ARRAY(SELECT unnest(movies.genres) UNION SELECT unnest(source.genres))), 0), 0)
+		+ 2 * COALESCE(co_ratings.raters::float8 / (co_ratings.raters + 5), 0)
+		+ similarity(movies.title, source.title)
+		+ 1 / (1 + abs(movies.year - source.year) / 5.0) AS score
+		FROM movies CROSS JOIN source
+		LEFT JOIN co_ratings ON co_ratings.movie_id = movies.id
+		WHERE movies.id <> source.id AND movies.deleted_at IS NULL
+		AND (movies.genres && source.genres OR co_ratings.movie_id IS NOT NULL OR movies.title % source.title)
+	)
+	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, rating, rating_count, poster, status, score
+	FROM scored
+	ORDER BY score DESC, id ASC
+	LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := model.DB.QueryContext(ctx, query, id, filters.limit(), filters.offset())
+
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(&totalRecords, &movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version, &movie.Rating, &movie.RatingCount, &movie.Poster, &movie.Status, &movie.Score)
 
 		if err != nil {
 			return nil, MetaData{}, err
@@ -80,26 +259,496 @@ func (model MovieModel) GetAll(title string, genres []string, filters Filters) (
 		movies = append(movies, &movie)
 	}
 
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 
 	return movies, metadata, nil
 }
 
-func (model MovieModel) Insert(movie *Movie) error {
-	query := `INSERT INTO movies(title,year,runtime,genres) VALUES($1,$2,$3,$4) RETURNING id,created_at, version`
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+// groups of movies sharing their normalised title and year, which are candidates for being the same movie
+// movieID limits the groups to the one of that movie, 0 returns every group
+func (model MovieModel) GetDuplicates(movieID int64, filters Filters) ([]*DuplicateGroup, MetaData, error) {
+	query := `
+	SELECT count(*) OVER(), normalise_title(title) AS normalised, year, array_agg(id ORDER BY id)
+	FROM movies
+	WHERE deleted_at IS NULL
+	AND ((normalise_title(title), year) = (SELECT normalise_title(title), year FROM movies WHERE id=$1) OR $1=0)
+	GROUP BY normalised, year
+	HAVING count(*) > 1
+	ORDER BY normalised, year
+	LIMIT $2 OFFSET $3`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return model.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	rows, err := model.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	groups := []*DuplicateGroup{}
+	groupIDs := [][]int64{}
+	ids := []int64{}
+
+	for rows.Next() {
+		var (
+			group    DuplicateGroup
+			movieIDs []int64
+		)
+
+		err := rows.Scan(&totalRecords, &group.Title, &group.Year, pq.Array(&movieIDs))
+
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		groups = append(groups, &group)
+		groupIDs = append(groupIDs, movieIDs)
+		ids = append(ids, movieIDs...)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	movies, err := model.GetByIDs(ids)
+
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	byID := make(map[int64]*Movie, len(movies))
+
+	for _, movie := range movies {
+		byID[movie.ID] = movie
+	}
+
+	for i, group := range groups {
+		group.Movies = []*Movie{}
+
+		//movies trashed since the groups were read are left out
+		for _, id := range groupIDs[i] {
+			if movie, ok := byID[id]; ok {
+				group.Movies = append(group.Movies, movie)
+			}
+		}
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return groups, metadata, nil
+}
+
+// inserts the movie and records it as the first revision, userID is the creating user
+func (model MovieModel) Insert(movie *Movie, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := model.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	//rollback is no-op once the transaction is committed
+	defer tx.Rollback()
+
+	err = insertMovie(ctx, tx, movie, userID)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertMovie(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64) error {
+	err := lockChanges(ctx, tx)
+
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO movies(title,year,runtime,genres,status) VALUES($1,$2,$3,$4,$5) RETURNING id,created_at, version`
+	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.Status}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+
+	if err != nil {
+		return err
+	}
+
+	err = insertExternalIDs(ctx, tx, movie.ID, movie.ExternalIDs)
+
+	if err != nil {
+		return err
+	}
+
+	err = insertRevision(ctx, tx, movie.ID, userID)
+
+	if err != nil {
+		return err
+	}
+
+	return insertChanges(ctx, tx, ChangeCreate, movie.ID)
+}
+
+// calls fn for every movie matching the filters, reading them through a server side cursor in batches
+// so the whole result is never held in memory, pagination values of filters are ignored
+func (model MovieModel) Export(ctx context.Context, search MovieSearch, filters Filters, fn func(*Movie) error) error {
+	tx, err := model.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	where, args := search.clause()
+
+	query := fmt.Sprintf(`
+	DECLARE movie_export NO SCROLL CURSOR FOR
+	SELECT id,created_at,title,year,runtime,genres,version,rating,rating_count,poster,status,
+	ARRAY(SELECT source FROM external_ids WHERE movie_id=movies.id ORDER BY source),
+	ARRAY(SELECT external_id FROM external_ids WHERE movie_id=movies.id ORDER BY source)
+	FROM movies WHERE %s
+	ORDER BY %s %s, id ASC
+	`, where, filters.SortColumn(), filters.SortDirection())
+
+	_, err = tx.ExecContext(ctx, query, args...)
+
+	if err != nil {
+		return err
+	}
+
+	for {
+		fetched, err := fetchMovies(ctx, tx, fn)
+
+		if err != nil {
+			return err
+		}
+
+		if fetched == 0 {
+			return nil
+		}
+	}
+}
+
+// fetches the next batch of the export cursor, returns number of movies fetched
+func fetchMovies(ctx context.Context, tx *sql.Tx, fn func(*Movie) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, `FETCH FORWARD 500 FROM movie_export`)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	fetched := 0
+
+	for rows.Next() {
+		var movie Movie
+		var sources, ids []string
+
+		err := rows.Scan(&movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version, &movie.Rating, &movie.RatingCount, &movie.Poster, &movie.Status, pq.Array(&sources), pq.Array(&ids))
+
+		if err != nil {
+			return 0, err
+		}
+
+		movie.ExternalIDs = ExternalIDs{}
+
+		for i, source := range sources {
+			movie.ExternalIDs[source] = ids[i]
+		}
+
+		err = fn(&movie)
+
+		if err != nil {
+			return 0, err
+		}
+
+		fetched++
+	}
+
+	return fetched, rows.Err()
+}
+
+// counts of movies an import created, changed and left as they were
+type ImportResult struct {
+	Created   int64 `json:"created"`
+	Updated   int64 `json:"updated"`
+	Unchanged int64 `json:"unchanged"`
+}
+
+// import rows which can't be matched to a single existing movie by their external ids
+// conflicts are keyed by the position of the movie in the import
+type ImportConflictError struct {
+	Conflicts map[int]string
+}
+
+func (e *ImportConflictError) Error() string {
+	return fmt.Sprintf("%d imported movies conflict with existing movies", len(e.Conflicts))
+}
+
+// bulk upserts movies with COPY in a single transaction and records their revisions
+// a movie whose external ids identify an existing movie updates it, every other movie is inserted
+// movies without a status keep the status of the movie they update and are inserted as released
+// rows are copied into temporary tables first so that matched and generated ids can be used for the revisions and external ids
+func (model MovieModel) Import(movies []*Movie, userID int64) (ImportResult, error) {
+	var result ImportResult
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := model.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return result, err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+	CREATE TEMPORARY TABLE movie_imports (position integer PRIMARY KEY, movie_id bigint, created boolean NOT NULL DEFAULT false, title text, year integer, runtime integer, genres text[], status text) ON COMMIT DROP;
+	CREATE TEMPORARY TABLE movie_import_ids (position integer, source text, external_id text) ON COMMIT DROP`)
+
+	if err != nil {
+		return result, err
+	}
+
+	err = copyRows(ctx, tx, pq.CopyIn("movie_imports", "position", "title", "year", "runtime", "genres", "status"), func(exec func(...any) error) error {
+		for i, movie := range movies {
+			err := exec(i, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), sql.NullString{String: movie.Status, Valid: movie.Status != ""})
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return result, err
+	}
+
+	err = copyRows(ctx, tx, pq.CopyIn("movie_import_ids", "position", "source", "external_id"), func(exec func(...any) error) error {
+		for i, movie := range movies {
+			for source, id := range movie.ExternalIDs {
+				err := exec(i, source, id)
+
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return result, err
+	}
+
+	query := `
+	WITH matched AS (
+		SELECT DISTINCT i.position, m.id AS movie_id, m.deleted_at IS NOT NULL AS trashed
+		FROM movie_import_ids i
+		INNER JOIN external_ids e ON e.source = i.source AND e.external_id = i.external_id
+		INNER JOIN movies m ON m.id = e.movie_id
+	), shared AS (
+		SELECT position, movie_id, trashed, count(*) OVER (PARTITION BY movie_id) AS positions
+		FROM matched
+	)
+	SELECT position, CASE
+		WHEN bool_or(trashed) THEN 'must not identify a movie in the trash'
+		WHEN count(*) > 1 THEN 'must not identify more than one movie'
+		ELSE 'must not identify the same movie as another row'
+	END
+	FROM shared
+	GROUP BY position
+	HAVING bool_or(trashed) OR count(*) > 1 OR max(positions) > 1`
+
+	rows, err := tx.QueryContext(ctx, query)
+
+	if err != nil {
+		return result, err
+	}
+
+	conflicts := make(map[int]string)
+
+	for rows.Next() {
+		var (
+			position int
+			message  string
+		)
+
+		err := rows.Scan(&position, &message)
+
+		if err != nil {
+			rows.Close()
+			return result, err
+		}
+
+		conflicts[position] = message
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return result, err
+	}
+
+	if len(conflicts) > 0 {
+		return result, &ImportConflictError{Conflicts: conflicts}
+	}
+
+	err = lockChanges(ctx, tx)
+
+	if err != nil {
+		return result, err
+	}
+
+	var editor *int64
+	if userID > 0 {
+		editor = &userID
+	}
+
+	//matched movies keep their id, new ones take ids from the sequence in import order
+	query = `
+	UPDATE movie_imports SET movie_id = matched.movie_id
+	FROM (
+		SELECT DISTINCT i.position, e.movie_id FROM movie_import_ids i
+		INNER JOIN external_ids e ON e.source = i.source AND e.external_id = i.external_id
+	) AS matched
+	WHERE movie_imports.position = matched.position;
+
+	UPDATE movie_imports SET movie_id = generated.id, created = true
+	FROM (
+		SELECT position, nextval(pg_get_serial_sequence('movies', 'id')) AS id
+		FROM (SELECT position FROM movie_imports WHERE movie_id IS NULL ORDER BY position) AS unmatched
+	) AS generated
+	WHERE movie_imports.position = generated.position`
+
+	_, err = tx.ExecContext(ctx, query)
+
+	if err != nil {
+		return result, err
+	}
+
+	//changes are written from the rows the movies statement returns, the statements of a query don't see each other's changes
+	query = fmt.Sprintf(`
+	WITH inserted AS (
+		INSERT INTO movies(id,title,year,runtime,genres,status)
+		SELECT movie_id,title,year,runtime,genres,COALESCE(status,'released') FROM movie_imports WHERE created ORDER BY position
+		RETURNING id,version,title,year,runtime,genres,status
+	), revisions AS (
+		INSERT INTO movie_revisions(movie_id,version,user_id,title,year,runtime,genres,status)
+		SELECT id,version,$1,title,year,runtime,genres,status FROM inserted
+	)
+	INSERT INTO movie_changes(movie_id,operation,version,movie)
+	SELECT id,'create',version,%s FROM inserted ORDER BY id`, movieChangeState)
+
+	res, err := tx.ExecContext(ctx, query, editor)
+
+	if err != nil {
+		return result, err
+	}
+
+	result.Created, err = res.RowsAffected()
+
+	if err != nil {
+		return result, err
+	}
+
+	query = fmt.Sprintf(`
+	WITH updated AS (
+		UPDATE movies SET title=i.title, year=i.year, runtime=i.runtime, genres=i.genres, status=COALESCE(i.status, movies.status), version=movies.version+1
+		FROM movie_imports i
+		WHERE movies.id = i.movie_id AND NOT i.created
+		AND (movies.title, movies.year, movies.runtime, movies.genres, movies.status) IS DISTINCT FROM (i.title, i.year, i.runtime, i.genres, COALESCE(i.status, movies.status))
+		RETURNING movies.id, movies.version, movies.title, movies.year, movies.runtime, movies.genres, movies.status
+	), revisions AS (
+		INSERT INTO movie_revisions(movie_id,version,user_id,title,year,runtime,genres,status)
+		SELECT id,version,$1,title,year,runtime,genres,status FROM updated
+	)
+	INSERT INTO movie_changes(movie_id,operation,version,movie)
+	SELECT id,'update',version,%s FROM updated ORDER BY id`, movieChangeState)
+
+	res, err = tx.ExecContext(ctx, query, editor)
+
+	if err != nil {
+		return result, err
+	}
+
+	result.Updated, err = res.RowsAffected()
+
+	if err != nil {
+		return result, err
+	}
+
+	result.Unchanged = int64(len(movies)) - result.Created - result.Updated
+
+	//an id of a source the movie is already known under replaces the old one, ids of other sources are kept
+	query = `
+	DELETE FROM external_ids e USING movie_import_ids i, movie_imports m
+	WHERE i.position = m.position AND e.movie_id = m.movie_id AND e.source = i.source AND e.external_id <> i.external_id;
+
+	INSERT INTO external_ids(source,external_id,movie_id)
+	SELECT i.source, i.external_id, m.movie_id FROM movie_import_ids i INNER JOIN movie_imports m ON m.position = i.position
+	ON CONFLICT DO NOTHING`
+
+	_, err = tx.ExecContext(ctx, query)
+
+	if err != nil {
+		return result, err
+	}
+
+	return result, tx.Commit()
+}
+
+// copies the rows fn passes to exec through the COPY statement
+func copyRows(ctx context.Context, tx *sql.Tx, copyStmt string, fn func(exec func(...any) error) error) error {
+	stmt, err := tx.PrepareContext(ctx, copyStmt)
+
+	if err != nil {
+		return err
+	}
+
+	err = fn(func(args ...any) error {
+		_, err := stmt.ExecContext(ctx, args...)
+		return err
+	})
+
+	if err != nil {
+		stmt.Close()
+		return err
+	}
+
+	//exec without arguments flushes the buffered rows
+	_, err = stmt.ExecContext(ctx)
+
+	if err != nil {
+		stmt.Close()
+		return err
+	}
+
+	return stmt.Close()
 }
 
 func (model MovieModel) Get(id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `SELECT id,created_at,title,year,runtime,version,genres FROM movies WHERE id=$1`
+	query := `SELECT id,created_at,title,year,runtime,version,genres,rating,rating_count,poster,status FROM movies WHERE id=$1 AND deleted_at IS NULL`
 	//to demo timeout
 	// query := `SELECT pg_sleep(7), id,created_at,title,year,runtime,version,genres FROM movies WHERE id=$1`
 
@@ -110,7 +759,7 @@ func (model MovieModel) Get(id int64) (*Movie, error) {
 	//cancel the context before the GET returns
 	defer cancel()
 
-	err := model.DB.QueryRowContext(ctx, query, id).Scan(&movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, &movie.Version, pq.Array(&movie.Genres))
+	err := model.DB.QueryRowContext(ctx, query, id).Scan(&movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, &movie.Version, pq.Array(&movie.Genres), &movie.Rating, &movie.RatingCount, &movie.Poster, &movie.Status)
 	//passing the context with timeout, terminates the long running query if it taken more that defined timeout
 	// err := model.DB.QueryRowContext(ctx, query, id).Scan(&[]byte{}, &movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, &movie.Version, pq.Array(&movie.Genres))
 
@@ -126,14 +775,39 @@ func (model MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (model MovieModel) Update(movie *Movie) error {
-	query := `UPDATE movies SET title=$1,year=$2,runtime=$3,genres=$4,version=version+1 WHERE id=$5 AND version=$6 RETURNING version`
-	args := []any{&movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.ID, &movie.Version}
-
+// updates the movie and records the new state as a revision in the same transaction, userID is the editing user
+func (model MovieModel) Update(movie *Movie, userID int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := model.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	tx, err := model.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = updateMovie(ctx, tx, movie, userID)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func updateMovie(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64) error {
+	err := lockChanges(ctx, tx)
+
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE movies SET title=$1,year=$2,runtime=$3,genres=$4,status=$5,version=version+1 WHERE id=$6 AND version=$7 AND deleted_at IS NULL RETURNING version`
+	args := []any{&movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Status, &movie.ID, &movie.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 
 	if err != nil {
 		switch {
@@ -144,16 +818,48 @@ func (model MovieModel) Update(movie *Movie) error {
 		}
 	}
 
-	return nil
-}
+	err = insertRevision(ctx, tx, movie.ID, userID)
+
+	if err != nil {
+		return err
+	}
 
-func (model MovieModel) Delete(id int64) error {
-	query := `DELETE FROM movies WHERE id=$1`
+	return insertChanges(ctx, tx, ChangeUpdate, movie.ID)
+}
 
+// moves the movie to the trash, it can be restored until it is purged
+// version is the version the client has seen, ErrEditConflict otherwise, 0 deletes whatever the version
+func (model MovieModel) Delete(id int64, version int32, userID int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := model.DB.ExecContext(ctx, query, id)
+	tx, err := model.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = deleteMovie(ctx, tx, id, version, userID)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func deleteMovie(ctx context.Context, tx *sql.Tx, id int64, version int32, userID int64) error {
+	err := lockChanges(ctx, tx)
+
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE movies SET deleted_at=NOW(),version=version+1 WHERE id=$1 AND (version=$2 OR $2=0) AND deleted_at IS NULL`
+
+	result, err := tx.ExecContext(ctx, query, id, version)
 
 	if err != nil {
 		return err
@@ -165,9 +871,224 @@ func (model MovieModel) Delete(id int64) error {
 		return err
 	}
 
+	if rowsAffected == 0 && version == 0 {
+		return ErrRecordNotFound
+	}
+
 	if rowsAffected == 0 {
+		var exists bool
+
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM movies WHERE id=$1 AND deleted_at IS NULL)`, id).Scan(&exists)
+
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			return ErrEditConflict
+		}
+
 		return ErrRecordNotFound
 	}
 
-	return nil
+	err = insertRevision(ctx, tx, id, userID)
+
+	if err != nil {
+		return err
+	}
+
+	return insertChanges(ctx, tx, ChangeDelete, id)
+}
+
+// bumps the version of a movie whose representation changed outside its own columns, such as its credits or poster,
+// and records the update as a revision by userID and in the change log
+// it locks the change log so it has to run before the transaction locks any movie
+// movie.Version must be the version the client has seen, ErrEditConflict otherwise
+// a version of 0 skips the check, a missing movie is ErrRecordNotFound then
+func bumpMovieVersion(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64) error {
+	err := lockChanges(ctx, tx)
+
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE movies SET version=version+1 WHERE id=$1 AND (version=$2 OR $2=0) AND deleted_at IS NULL RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, movie.ID, movie.Version).Scan(&movie.Version)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows) && movie.Version == 0:
+			return ErrRecordNotFound
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = insertRevision(ctx, tx, movie.ID, userID)
+
+	if err != nil {
+		return err
+	}
+
+	return insertChanges(ctx, tx, ChangeUpdate, movie.ID)
+}
+
+func (model MovieModel) GetAllDeleted(filters Filters) ([]*Movie, MetaData, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id,created_at,title,year,runtime,genres,version,rating,rating_count,poster,status,deleted_at
+	FROM movies WHERE deleted_at IS NOT NULL
+	ORDER BY %s %s, id ASC
+	LIMIT $1 OFFSET $2
+	`, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := model.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(&totalRecords, &movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version, &movie.Rating, &movie.RatingCount, &movie.Poster, &movie.Status, &movie.DeletedAt)
+
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// takes the movie out of the trash, it's recorded as created in the change log since it reappears in the catalog
+// userID is the restoring user
+func (model MovieModel) Restore(id int64, userID int64) (*Movie, error) {
+	query := `UPDATE movies SET deleted_at=NULL,version=version+1 WHERE id=$1 AND deleted_at IS NOT NULL RETURNING id,created_at,title,year,runtime,version,genres,rating,rating_count,poster,status`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := model.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	err = lockChanges(ctx, tx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var movie Movie
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, &movie.Version, pq.Array(&movie.Genres), &movie.Rating, &movie.RatingCount, &movie.Poster, &movie.Status)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = insertRevision(ctx, tx, movie.ID, userID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertChanges(ctx, tx, ChangeCreate, movie.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &movie, tx.Commit()
+}
+
+// permanently removes a movie which is in the trash, returning the storage keys of its poster
+func (model MovieModel) Purge(id int64) ([]string, error) {
+	query := `DELETE FROM movies WHERE id=$1 AND deleted_at IS NOT NULL RETURNING poster_keys`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var keys []string
+
+	err := model.DB.QueryRowContext(ctx, query, id).Scan(pq.Array(&keys))
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// permanently removes every movie moved to the trash before the cutoff
+// returns number of movies purged and the storage keys of their posters
+func (model MovieModel) PurgeDeletedBefore(cutoff time.Time) (int64, []string, error) {
+	query := `DELETE FROM movies WHERE deleted_at IS NOT NULL AND deleted_at < $1 RETURNING poster_keys`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := model.DB.QueryContext(ctx, query, cutoff)
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer rows.Close()
+
+	var (
+		purged int64
+		keys   []string
+	)
+
+	for rows.Next() {
+		var posterKeys []string
+
+		err := rows.Scan(pq.Array(&posterKeys))
+
+		if err != nil {
+			return 0, nil, err
+		}
+
+		purged++
+		keys = append(keys, posterKeys...)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	return purged, keys, nil
 }
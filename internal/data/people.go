@@ -0,0 +1,387 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"movies.samkha.net/internal/validator"
+)
+
+var (
+	ErrUnknownPerson = errors.New("unknown person")
+)
+
+const (
+	RoleDirector = "director"
+	RoleWriter   = "writer"
+	RoleActor    = "actor"
+)
+
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	BirthYear *int32    `json:"birth_year,omitempty"`
+	Biography string    `json:"biography,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// a person credited on a movie, a person may hold several roles on the same movie
+type Credit struct {
+	PersonID     int64  `json:"person_id"`
+	Name         string `json:"name"`
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"`
+	BillingOrder int32  `json:"billing_order"`
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	if person.BirthYear != nil {
+		v.Check(*person.BirthYear >= 1800, "birth_year", "must be greater than 1800")
+		v.Check(*person.BirthYear <= int32(time.Now().Year()), "birth_year", "must not be in the future")
+	}
+
+	v.Check(len(person.Biography) <= 10_000, "biography", "must not be more than 10000 bytes long")
+}
+
+func ValidateCredits(v *validator.Validator, credits []*Credit) {
+	v.Check(len(credits) <= 500, "credits", "must not contain more than 500 credits")
+
+	type key struct {
+		personID int64
+		role     string
+	}
+
+	seen := make(map[key]bool)
+
+	for _, credit := range credits {
+		v.Check(credit.PersonID > 0, "credits", "person_id must be provided for every credit")
+		v.Check(validator.PermittedValue(credit.Role, RoleDirector, RoleWriter, RoleActor), "credits", "role must be director, writer or actor")
+		v.Check(credit.Role == RoleActor || credit.Character == "", "credits", "character is only allowed for actors")
+		v.Check(len(credit.Character) <= 500, "credits", "character must not be more than 500 bytes long")
+		v.Check(credit.BillingOrder >= 0, "credits", "billing_order must not be negative")
+		v.Check(!seen[key{credit.PersonID, credit.Role}], "credits", "must not contain the same person twice with the same role")
+
+		seen[key{credit.PersonID, credit.Role}] = true
+	}
+}
+
+type PersonModel struct {
+	DB *sql.DB
+}
+
+func (m PersonModel) GetAll(name string, filters Filters) ([]*Person, MetaData, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id,created_at,name,birth_year,biography,version
+	FROM people WHERE (to_tsvector('simple',name) @@ plainto_tsquery('simple', $1) OR $1='')
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3
+	`, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+
+	for rows.Next() {
+		var person Person
+
+		err := rows.Scan(&totalRecords, &person.ID, &person.CreatedAt, &person.Name, &person.BirthYear, &person.Biography, &person.Version)
+
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		people = append(people, &person)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return people, metadata, nil
+}
+
+func (m PersonModel) Insert(person *Person) error {
+	query := `INSERT INTO people(name,birth_year,biography) VALUES($1,$2,$3) RETURNING id,created_at,version`
+	args := []any{person.Name, person.BirthYear, person.Biography}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+func (m PersonModel) Get(id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id,created_at,name,birth_year,biography,version FROM people WHERE id=$1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var person Person
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&person.ID, &person.CreatedAt, &person.Name, &person.BirthYear, &person.Biography, &person.Version)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+// updates the person, a new name bumps the versions of the movies crediting them since their credits show it
+// userID is the editing user, recorded in the revisions of those movies
+func (m PersonModel) Update(person *Person, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = lockChanges(ctx, tx)
+
+	if err != nil {
+		return err
+	}
+
+	var previous string
+
+	err = tx.QueryRowContext(ctx, `SELECT name FROM people WHERE id=$1 AND version=$2 FOR UPDATE`, person.ID, person.Version).Scan(&previous)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query := `UPDATE people SET name=$1,birth_year=$2,biography=$3,version=version+1 WHERE id=$4 RETURNING version`
+	args := []any{person.Name, person.BirthYear, person.Biography, person.ID}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+
+	if err != nil {
+		return err
+	}
+
+	if person.Name != previous {
+		err = bumpCreditedMovies(ctx, tx, person.ID, userID)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// deletes the person along with all of their credits, bumping the versions of the movies which lose them
+func (m PersonModel) Delete(id int64, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	//taken before the person is locked, credits being added for them hold the change log while waiting on the person
+	err = lockChanges(ctx, tx)
+
+	if err != nil {
+		return err
+	}
+
+	err = bumpCreditedMovies(ctx, tx, id, userID)
+
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM people WHERE id=$1`, id)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// bumps the versions of the movies crediting the person, movies in the trash are bumped when they're restored
+func bumpCreditedMovies(ctx context.Context, tx *sql.Tx, personID int64, userID int64) error {
+	query := `
+	SELECT DISTINCT movie_credits.movie_id
+	FROM movie_credits INNER JOIN movies ON movies.id = movie_credits.movie_id
+	WHERE movie_credits.person_id = $1 AND movies.deleted_at IS NULL
+	ORDER BY movie_credits.movie_id`
+
+	rows, err := tx.QueryContext(ctx, query, personID)
+
+	if err != nil {
+		return err
+	}
+
+	ids := []int64{}
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		ids = append(ids, id)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err = bumpMovieVersion(ctx, tx, &Movie{ID: id}, userID)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// credits of the movie ordered by role and billing order
+func (m PersonModel) GetCreditsForMovie(movieID int64) ([]*Credit, error) {
+	query := `
+	SELECT movie_credits.person_id, people.name, movie_credits.role, movie_credits.character, movie_credits.billing_order
+	FROM movie_credits INNER JOIN people ON people.id = movie_credits.person_id
+	WHERE movie_credits.movie_id = $1
+	ORDER BY array_position(ARRAY['director','writer','actor'], movie_credits.role), movie_credits.billing_order, people.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(&credit.PersonID, &credit.Name, &credit.Role, &credit.Character, &credit.BillingOrder)
+
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// replaces all credits of the movie and bumps the movie version so cached representations are invalidated
+// movie.Version must be the version the client has seen, ErrEditConflict otherwise
+func (m PersonModel) ReplaceCredits(movie *Movie, credits []*Credit, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = bumpMovieVersion(ctx, tx, movie, userID)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_credits WHERE movie_id=$1`, movie.ID)
+
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO movie_credits(movie_id,person_id,role,character,billing_order) VALUES($1,$2,$3,$4,$5)`
+
+	for _, credit := range credits {
+		_, err = tx.ExecContext(ctx, query, movie.ID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder)
+
+		if err != nil {
+			var pqErr *pq.Error
+
+			//foreign_key_violation
+			if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+				return ErrUnknownPerson
+			}
+
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,76 @@
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// image urls by size name, stored as a jsonb object
+type ImageURLs map[string]string
+
+func (u *ImageURLs) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		*u = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(src, u)
+	case string:
+		return json.Unmarshal([]byte(src), u)
+	default:
+		return fmt.Errorf("cannot scan %T into ImageURLs", src)
+	}
+}
+
+func (u ImageURLs) Value() (driver.Value, error) {
+	if u == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(u)
+}
+
+// replaces the poster of the movie and bumps its version, returning the storage keys of the replaced poster
+// movie.Version must be the version the client has seen, ErrEditConflict otherwise
+func (model MovieModel) SetPoster(movie *Movie, urls ImageURLs, keys []string, userID int64) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := model.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	err = bumpMovieVersion(ctx, tx, movie, userID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var previous []string
+
+	//the movie row is locked by the version bump
+	err = tx.QueryRowContext(ctx, `SELECT poster_keys FROM movies WHERE id=$1`, movie.ID).Scan(pq.Array(&previous))
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE movies SET poster=$1,poster_keys=$2 WHERE id=$3`, urls, pq.Array(keys), movie.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	movie.Poster = urls
+
+	return previous, tx.Commit()
+}
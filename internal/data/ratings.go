@@ -0,0 +1,138 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"movies.samkha.net/internal/validator"
+)
+
+type Rating struct {
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"-"`
+	Rating    int16     `json:"rating"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func ValidateRating(v *validator.Validator, rating *Rating) {
+	v.Check(rating.Rating >= 1, "rating", "must be at least 1")
+	v.Check(rating.Rating <= 10, "rating", "must not be more than 10")
+}
+
+type RatingModel struct {
+	DB *sql.DB
+}
+
+// locks the movie row so concurrent ratings of the same movie update the aggregates one at a time
+func lockMovieForRating(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	var id int64
+
+	err := tx.QueryRowContext(ctx, `SELECT id FROM movies WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`, movieID).Scan(&id)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// creates or replaces the user's rating of the movie and updates the movie's rating aggregates
+func (m RatingModel) Upsert(rating *Rating) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = lockMovieForRating(ctx, tx, rating.MovieID)
+
+	if err != nil {
+		return err
+	}
+
+	var previous int16
+
+	err = tx.QueryRowContext(ctx, `SELECT rating FROM ratings WHERE user_id=$1 AND movie_id=$2`, rating.UserID, rating.MovieID).Scan(&previous)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	query := `
+	INSERT INTO ratings(user_id,movie_id,rating) VALUES($1,$2,$3)
+	ON CONFLICT (user_id,movie_id) DO UPDATE SET rating=EXCLUDED.rating, updated_at=NOW()
+	RETURNING created_at,updated_at`
+
+	err = tx.QueryRowContext(ctx, query, rating.UserID, rating.MovieID, rating.Rating).Scan(&rating.CreatedAt, &rating.UpdatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	newRatings := 0
+	if previous == 0 {
+		newRatings = 1
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE movies SET rating_sum=rating_sum+$1, rating_count=rating_count+$2 WHERE id=$3`, rating.Rating-previous, newRatings, rating.MovieID)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// removes the user's rating of the movie and takes it out of the movie's rating aggregates
+func (m RatingModel) Delete(userID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = lockMovieForRating(ctx, tx, movieID)
+
+	if err != nil {
+		return err
+	}
+
+	var previous int16
+
+	err = tx.QueryRowContext(ctx, `DELETE FROM ratings WHERE user_id=$1 AND movie_id=$2 RETURNING rating`, userID, movieID).Scan(&previous)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE movies SET rating_sum=rating_sum-$1, rating_count=rating_count-1 WHERE id=$2`, previous, movieID)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,373 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrRunInProgress = errors.New("recommendation run in progress")
+)
+
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+
+	TriggerScheduled = "scheduled"
+	TriggerManual    = "manual"
+)
+
+const (
+	// upper bound for a whole run, runs still marked running after it are considered dead
+	recommendationsTimeout = 10 * time.Minute
+	// users two movies must have in common before they count as similar
+	minCommonUsers = 2
+	// similar movies kept per movie
+	neighboursPerMovie = 50
+	// recommendations kept per user
+	recommendationsPerUser = 100
+)
+
+type RecommendationRun struct {
+	ID         int64      `json:"id"`
+	Trigger    string     `json:"trigger"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Users      int64      `json:"users"` // users who got recommendations
+	Error      string     `json:"error,omitempty"`
+}
+
+type RecommendationModel struct {
+	DB *sql.DB
+}
+
+// reports whether recommendations have been computed for the user
+func (m RecommendationModel) Exists(userID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM recommendations WHERE user_id=$1)`, userID).Scan(&exists)
+
+	return exists, err
+}
+
+// precomputed recommendations of the user, best first
+// movies the user interacted with since the last run are left out
+func (m RecommendationModel) GetForUser(userID int64, filters Filters) ([]*Movie, MetaData, error) {
+	query := `
+	SELECT count(*) OVER(), movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version, movies.rating, movies.rating_count, movies.poster, movies.status, recommendations.score
+	FROM recommendations INNER JOIN movies ON movies.id = recommendations.movie_id
+	WHERE recommendations.user_id = $1 AND movies.deleted_at IS NULL
+	AND NOT EXISTS (SELECT 1 FROM ratings WHERE ratings.user_id = $1 AND ratings.movie_id = movies.id)
+	AND NOT EXISTS (SELECT 1 FROM watchlist WHERE watchlist.user_id = $1 AND watchlist.movie_id = movies.id)
+	AND NOT EXISTS (SELECT 1 FROM watched WHERE watched.user_id = $1 AND watched.movie_id = movies.id)
+	ORDER BY recommendations.score DESC, movies.id ASC
+	LIMIT $2 OFFSET $3`
+
+	return m.scoredMovies(query, userID, filters)
+}
+
+// cold start fallback: well rated movies in the genres the user favours, or in any genre when nothing is known about the user
+// ratings are shrunk towards 6 so a handful of votes can't beat a widely rated movie
+func (m RecommendationModel) GetPopular(userID int64, filters Filters) ([]*Movie, MetaData, error) {
+	query := `
+	WITH liked AS (
+		SELECT movie_id FROM ratings WHERE user_id = $1 AND rating >= 7
+		UNION SELECT movie_id FROM watchlist WHERE user_id = $1
+		UNION SELECT movie_id FROM watched WHERE user_id = $1
+	), favoured AS (
+		SELECT genre FROM (SELECT unnest(movies.genres) AS genre FROM movies INNER JOIN liked ON liked.movie_id = movies.id) AS genres
+		GROUP BY genre ORDER BY count(*) DESC, genre LIMIT 3
+	)
+	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, rating, rating_count, poster, status,
+	((rating * rating_count + 6 * 10) / (rating_count + 10))::float8 AS score
+	FROM movies
+	WHERE deleted_at IS NULL AND status = 'released'
+	AND (NOT EXISTS (SELECT 1 FROM favoured) OR genres && ARRAY(SELECT genre FROM favoured))
+	AND NOT EXISTS (SELECT 1 FROM ratings WHERE ratings.user_id = $1 AND ratings.movie_id = movies.id)
+	AND id NOT IN (SELECT movie_id FROM liked)
+	ORDER BY score DESC, rating_count DESC, id ASC
+	LIMIT $2 OFFSET $3`
+
+	return m.scoredMovies(query, userID, filters)
+}
+
+func (m RecommendationModel) scoredMovies(query string, userID int64, filters Filters) ([]*Movie, MetaData, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(&totalRecords, &movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version, &movie.Rating, &movie.RatingCount, &movie.Poster, &movie.Status, &movie.Score)
+
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// rebuilds movie similarities and recommendations of every user, returning the number of users who got recommendations
+//
+// ratings are centred so low ratings count against a movie, watchlist and watched entries are weaker positive signals.
+// movies are similar when the same users feel the same about them (cosine similarity),
+// and a user's candidates are scored by summing the similarities to the movies they interacted with weighted by that interaction
+func (m RecommendationModel) Compute() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), recommendationsTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	//explicit ratings win over the implicit signals of the same movie
+	query := `
+	CREATE TEMP TABLE interactions ON COMMIT DROP AS
+	SELECT signals.user_id, signals.movie_id, (array_agg(signals.weight ORDER BY signals.explicit DESC, signals.weight DESC))[1] AS weight
+	FROM (
+		SELECT user_id, movie_id, (rating - 5.5) / 4.5 AS weight, true AS explicit FROM ratings
+		UNION ALL SELECT user_id, movie_id, 0.7, false FROM watched
+		UNION ALL SELECT user_id, movie_id, 0.5, false FROM watchlist
+	) AS signals
+	INNER JOIN movies ON movies.id = signals.movie_id AND movies.deleted_at IS NULL
+	GROUP BY signals.user_id, signals.movie_id`
+
+	_, err = tx.ExecContext(ctx, query)
+
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+	CREATE TEMP TABLE norms ON COMMIT DROP AS
+	SELECT movie_id, sqrt(sum(weight * weight)) AS norm FROM interactions GROUP BY movie_id`
+
+	_, err = tx.ExecContext(ctx, query)
+
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_similarities`)
+
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+	INSERT INTO movie_similarities(movie_id, similar_movie_id, score)
+	SELECT movie_id, similar_movie_id, score FROM (
+		SELECT pairs.*, row_number() OVER (PARTITION BY movie_id ORDER BY score DESC, similar_movie_id) AS rank
+		FROM (
+			SELECT a.movie_id, b.movie_id AS similar_movie_id, (sum(a.weight * b.weight) / (na.norm * nb.norm))::float8 AS score
+			FROM interactions a
+			INNER JOIN interactions b ON b.user_id = a.user_id AND b.movie_id <> a.movie_id
+			INNER JOIN norms na ON na.movie_id = a.movie_id
+			INNER JOIN norms nb ON nb.movie_id = b.movie_id
+			GROUP BY a.movie_id, b.movie_id, na.norm, nb.norm
+			HAVING count(*) >= $1
+		) AS pairs
+		WHERE score > 0
+	) AS neighbours
+	WHERE rank <= $2`
+
+	_, err = tx.ExecContext(ctx, query, minCommonUsers, neighboursPerMovie)
+
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recommendations`)
+
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+	INSERT INTO recommendations(user_id, movie_id, score)
+	SELECT user_id, movie_id, score FROM (
+		SELECT candidates.*, row_number() OVER (PARTITION BY user_id ORDER BY score DESC, movie_id) AS rank
+		FROM (
+			SELECT interactions.user_id, movie_similarities.similar_movie_id AS movie_id, sum(movie_similarities.score * interactions.weight)::float8 AS score
+			FROM interactions INNER JOIN movie_similarities ON movie_similarities.movie_id = interactions.movie_id
+			WHERE NOT EXISTS (
+				SELECT 1 FROM interactions seen WHERE seen.user_id = interactions.user_id AND seen.movie_id = movie_similarities.similar_movie_id
+			)
+			GROUP BY interactions.user_id, movie_similarities.similar_movie_id
+		) AS candidates
+		WHERE score > 0
+	) AS ranked
+	WHERE rank <= $1`
+
+	_, err = tx.ExecContext(ctx, query, recommendationsPerUser)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var users int64
+
+	err = tx.QueryRowContext(ctx, `SELECT count(DISTINCT user_id) FROM recommendations`).Scan(&users)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return users, tx.Commit()
+}
+
+// records the start of a run, fails with ErrRunInProgress while another run is going
+// runs left running for longer than a run can take died with their process, so they are marked as failed first
+func (m RecommendationModel) StartRun(trigger string) (*RecommendationRun, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+	UPDATE recommendation_runs SET status=$1, error='interrupted', finished_at=NOW()
+	WHERE status=$2 AND started_at < NOW() - $3::interval`
+
+	_, err := m.DB.ExecContext(ctx, query, RunFailed, RunRunning, fmt.Sprintf("%d seconds", int(recommendationsTimeout.Seconds())))
+
+	if err != nil {
+		return nil, err
+	}
+
+	run := &RecommendationRun{Trigger: trigger}
+
+	query = `INSERT INTO recommendation_runs(trigger,status) VALUES($1,$2) RETURNING id,status,started_at`
+
+	err = m.DB.QueryRowContext(ctx, query, trigger, RunRunning).Scan(&run.ID, &run.Status, &run.StartedAt)
+
+	if err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return nil, ErrRunInProgress
+		default:
+			return nil, err
+		}
+	}
+
+	return run, nil
+}
+
+// records the outcome of the run, runErr is the error the run failed with if any
+func (m RecommendationModel) FinishRun(run *RecommendationRun, users int64, runErr error) error {
+	run.Status = RunSucceeded
+	run.Users = users
+	run.Error = ""
+
+	if runErr != nil {
+		run.Status = RunFailed
+		run.Error = runErr.Error()
+	}
+
+	query := `UPDATE recommendation_runs SET status=$1, users=$2, error=$3, finished_at=NOW() WHERE id=$4 RETURNING finished_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, run.Status, run.Users, run.Error, run.ID).Scan(&run.FinishedAt)
+}
+
+func (m RecommendationModel) GetRun(id int64) (*RecommendationRun, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id,trigger,status,started_at,finished_at,users,error FROM recommendation_runs WHERE id=$1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var run RecommendationRun
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&run.ID, &run.Trigger, &run.Status, &run.StartedAt, &run.FinishedAt, &run.Users, &run.Error)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &run, nil
+}
+
+func (m RecommendationModel) GetAllRuns(filters Filters) ([]*RecommendationRun, MetaData, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id,trigger,status,started_at,finished_at,users,error
+	FROM recommendation_runs
+	ORDER BY %s %s, id DESC
+	LIMIT $1 OFFSET $2
+	`, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	runs := []*RecommendationRun{}
+
+	for rows.Next() {
+		var run RecommendationRun
+
+		err := rows.Scan(&totalRecords, &run.ID, &run.Trigger, &run.Status, &run.StartedAt, &run.FinishedAt, &run.Users, &run.Error)
+
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		runs = append(runs, &run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return runs, metadata, nil
+}
@@ -0,0 +1,203 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"movies.samkha.net/internal/validator"
+)
+
+const (
+	MovieReleased = "released"
+	MovieUpcoming = "upcoming" // announced but not released yet, its year may be in the future
+)
+
+var CountryRx = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// minimum ages of the certifications of the rating systems we know, by country
+// certifications of other countries are stored as given, with the age taken from the certification when it's a number
+var certifications = map[string]map[string]int{
+	"AU": {"G": 0, "PG": 0, "M": 0, "MA15+": 15, "R18+": 18, "X18+": 18},
+	"DE": {"0": 0, "6": 6, "12": 12, "16": 16, "18": 18},
+	"ES": {"A": 0, "7": 7, "12": 12, "16": 16, "18": 18},
+	"FR": {"U": 0, "12": 12, "16": 16, "18": 18},
+	"GB": {"U": 0, "PG": 0, "12A": 12, "12": 12, "15": 15, "18": 18, "R18": 18},
+	"IE": {"G": 0, "PG": 0, "12A": 12, "15A": 15, "16": 16, "18": 18},
+	"NL": {"AL": 0, "6": 6, "9": 9, "12": 12, "14": 14, "16": 16, "18": 18},
+	"US": {"G": 0, "PG": 0, "PG-13": 13, "R": 17, "NC-17": 18},
+}
+
+// release of a movie in a country
+type Release struct {
+	Country       string `json:"country"`
+	Date          string `json:"date"` // yyyy-mm-dd
+	Certification string `json:"certification,omitempty"`
+	MinimumAge    *int   `json:"minimum_age,omitempty"` // derived from the certification unless the country's system is unknown
+}
+
+// fills in the minimum age implied by the certification, an age given for a known certification is replaced
+func (r *Release) deriveMinimumAge() {
+	if system, ok := certifications[r.Country]; ok {
+		if age, ok := system[r.Certification]; ok {
+			r.MinimumAge = &age
+		}
+
+		return
+	}
+
+	if age, err := strconv.Atoi(r.Certification); err == nil && r.MinimumAge == nil {
+		r.MinimumAge = &age
+	}
+}
+
+// country codes are upper cased and minimum ages derived before validating
+func ValidateReleases(v *validator.Validator, releases []*Release) {
+	v.Check(len(releases) <= 250, "releases", "must not contain more than 250 releases")
+
+	countries := make([]string, 0, len(releases))
+
+	for _, release := range releases {
+		release.Country = strings.ToUpper(strings.TrimSpace(release.Country))
+		release.Certification = strings.ToUpper(strings.TrimSpace(release.Certification))
+
+		if !validator.Matches(release.Country, CountryRx) {
+			v.AddError("releases", fmt.Sprintf("country %q must be a two letter country code", release.Country))
+			continue
+		}
+
+		countries = append(countries, release.Country)
+
+		date, err := time.Parse(time.DateOnly, release.Date)
+
+		v.Check(err == nil, "releases", fmt.Sprintf("%s date must be a date like 2006-01-02", release.Country))
+		v.Check(err != nil || date.Year() >= 1888, "releases", fmt.Sprintf("%s date must not be before 1888", release.Country))
+
+		if system, ok := certifications[release.Country]; ok && release.Certification != "" {
+			if _, ok := system[release.Certification]; !ok {
+				v.AddError("releases", fmt.Sprintf("%s certification must be one of %s", release.Country, strings.Join(certificationNames(system), ", ")))
+			}
+		}
+
+		v.Check(validator.MaxChars(release.Certification, 20), "releases", fmt.Sprintf("%s certification must not be more than 20 characters long", release.Country))
+
+		release.deriveMinimumAge()
+
+		v.Check(release.MinimumAge == nil || *release.MinimumAge >= 0 && *release.MinimumAge <= 21, "releases", fmt.Sprintf("%s minimum age must be between 0 and 21", release.Country))
+	}
+
+	v.Check(validator.Unique(countries), "releases", "must not contain more than one release per country")
+}
+
+// certifications of a rating system, youngest audience first
+func certificationNames(system map[string]int) []string {
+	names := make([]string, 0, len(system))
+
+	for name := range system {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if system[names[i]] != system[names[j]] {
+			return system[names[i]] < system[names[j]]
+		}
+
+		return names[i] < names[j]
+	})
+
+	return names
+}
+
+type ReleaseModel struct {
+	DB *sql.DB
+}
+
+func (m ReleaseModel) GetForMovie(movieID int64) ([]*Release, error) {
+	query := `
+	SELECT country, release_date::text, certification, minimum_age
+	FROM movie_releases WHERE movie_id=$1
+	ORDER BY release_date, country`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	releases := []*Release{}
+
+	for rows.Next() {
+		var (
+			release    Release
+			minimumAge sql.NullInt16
+		)
+
+		err := rows.Scan(&release.Country, &release.Date, &release.Certification, &minimumAge)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if minimumAge.Valid {
+			age := int(minimumAge.Int16)
+			release.MinimumAge = &age
+		}
+
+		releases = append(releases, &release)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return releases, nil
+}
+
+// replaces all releases of the movie and bumps the movie version so cached representations are invalidated
+// movie.Version must be the version the client has seen, ErrEditConflict otherwise
+func (m ReleaseModel) Replace(movie *Movie, releases []*Release, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = bumpMovieVersion(ctx, tx, movie, userID)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_releases WHERE movie_id=$1`, movie.ID)
+
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO movie_releases(movie_id,country,release_date,certification,minimum_age) VALUES($1,$2,$3,$4,$5)`
+
+	for _, release := range releases {
+		_, err = tx.ExecContext(ctx, query, movie.ID, release.Country, release.Date, release.Certification, release.MinimumAge)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,286 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"movies.samkha.net/internal/validator"
+)
+
+var (
+	ErrDuplicateReview = errors.New("duplicate review")
+	ErrDuplicateReport = errors.New("duplicate report")
+)
+
+type Review struct {
+	ID           int64     `json:"id"`
+	MovieID      int64     `json:"movie_id"`
+	UserID       int64     `json:"user_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Title        string    `json:"title"`
+	Body         string    `json:"body"`
+	Hidden       bool      `json:"hidden,omitempty"`
+	HiddenReason string    `json:"hidden_reason,omitempty"`
+	Version      int32     `json:"version"`
+	ReportCount  int64     `json:"report_count,omitempty"` // only loaded for moderators
+}
+
+type ReviewReport struct {
+	ReviewID  int64     `json:"review_id"`
+	UserID    int64     `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Reason    string    `json:"reason"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(validator.NotBlank(review.Title), "title", "must be provided")
+	v.Check(validator.MaxChars(review.Title, 200), "title", "must not be more than 200 characters long")
+	v.Check(validator.PrintableText(review.Title), "title", "must not contain control characters")
+
+	v.Check(validator.NotBlank(review.Body), "body", "must be provided")
+	v.Check(validator.MinChars(review.Body, 20), "body", "must be at least 20 characters long")
+	v.Check(validator.MaxChars(review.Body, 10_000), "body", "must not be more than 10000 characters long")
+	v.Check(validator.PrintableText(review.Body), "body", "must not contain control characters")
+}
+
+func ValidateReviewReport(v *validator.Validator, report *ReviewReport) {
+	v.Check(validator.NotBlank(report.Reason), "reason", "must be provided")
+	v.Check(validator.MaxChars(report.Reason, 1000), "reason", "must not be more than 1000 characters long")
+	v.Check(validator.PrintableText(report.Reason), "reason", "must not contain control characters")
+}
+
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// reviews of a movie, hidden reviews are only included when includeHidden is set
+func (m ReviewModel) GetAllForMovie(movieID int64, includeHidden bool, filters Filters) ([]*Review, MetaData, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id,movie_id,user_id,created_at,updated_at,title,body,hidden,hidden_reason,version
+	FROM reviews WHERE movie_id=$1 AND (NOT hidden OR $2)
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4
+	`, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, includeHidden, filters.limit(), filters.offset())
+
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(&totalRecords, &review.ID, &review.MovieID, &review.UserID, &review.CreatedAt, &review.UpdatedAt, &review.Title, &review.Body, &review.Hidden, &review.HiddenReason, &review.Version)
+
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// reviews with at least one report, most reported first
+func (m ReviewModel) GetAllReported(filters Filters) ([]*Review, MetaData, error) {
+	query := `
+	SELECT count(*) OVER(), reviews.id,reviews.movie_id,reviews.user_id,reviews.created_at,reviews.updated_at,reviews.title,reviews.body,reviews.hidden,reviews.hidden_reason,reviews.version,count(review_reports.user_id)
+	FROM reviews INNER JOIN review_reports ON review_reports.review_id = reviews.id
+	GROUP BY reviews.id
+	ORDER BY count(review_reports.user_id) DESC, reviews.id ASC
+	LIMIT $1 OFFSET $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(&totalRecords, &review.ID, &review.MovieID, &review.UserID, &review.CreatedAt, &review.UpdatedAt, &review.Title, &review.Body, &review.Hidden, &review.HiddenReason, &review.Version, &review.ReportCount)
+
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// ErrRecordNotFound is returned when the movie doesn't exist or is in the trash
+func (m ReviewModel) Insert(review *Review) error {
+	query := `
+	INSERT INTO reviews(movie_id,user_id,title,body)
+	SELECT $1,$2,$3,$4 WHERE EXISTS(SELECT 1 FROM movies WHERE id=$1 AND deleted_at IS NULL)
+	RETURNING id,created_at,updated_at,version`
+	args := []any{review.MovieID, review.UserID, review.Title, review.Body}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt, &review.Version)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case isUniqueViolation(err):
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// returns the review only if it belongs to the movie
+func (m ReviewModel) Get(movieID, id int64) (*Review, error) {
+	if movieID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id,movie_id,user_id,created_at,updated_at,title,body,hidden,hidden_reason,version FROM reviews WHERE id=$1 AND movie_id=$2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var review Review
+
+	err := m.DB.QueryRowContext(ctx, query, id, movieID).Scan(&review.ID, &review.MovieID, &review.UserID, &review.CreatedAt, &review.UpdatedAt, &review.Title, &review.Body, &review.Hidden, &review.HiddenReason, &review.Version)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// saves title, body and moderation state of the review
+func (m ReviewModel) Update(review *Review) error {
+	query := `UPDATE reviews SET title=$1,body=$2,hidden=$3,hidden_reason=$4,updated_at=NOW(),version=version+1 WHERE id=$5 AND version=$6 RETURNING updated_at,version`
+	args := []any{review.Title, review.Body, review.Hidden, review.HiddenReason, review.ID, review.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.UpdatedAt, &review.Version)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m ReviewModel) Delete(id int64) error {
+	query := `DELETE FROM reviews WHERE id=$1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m ReviewModel) InsertReport(report *ReviewReport) error {
+	query := `INSERT INTO review_reports(review_id,user_id,reason) VALUES($1,$2,$3) RETURNING created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, report.ReviewID, report.UserID, report.Reason).Scan(&report.CreatedAt)
+
+	if err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return ErrDuplicateReport
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// marks all reports of the review as handled by a moderator
+func (m ReviewModel) DeleteReports(reviewID int64) error {
+	query := `DELETE FROM review_reports WHERE review_id=$1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, reviewID)
+
+	return err
+}
@@ -0,0 +1,159 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// snapshot of a movie as it was saved at a given version, every version has one
+// versions which changed something else than these fields, like the credits or the poster, repeat the previous values
+type MovieRevision struct {
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    *int64    `json:"user_id"` // nil when the editing user no longer exists
+	Title     string    `json:"title"`
+	Year      int32     `json:"year"`
+	Runtime   Runtime   `json:"runtime"`
+	Genres    []string  `json:"genres"`
+	Status    string    `json:"status"`
+}
+
+type FieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// returns fields that differ between the previous revision and this revision
+func (rev *MovieRevision) Diff(prev *MovieRevision) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+
+	if prev.Title != rev.Title {
+		changes["title"] = FieldChange{From: prev.Title, To: rev.Title}
+	}
+
+	if prev.Year != rev.Year {
+		changes["year"] = FieldChange{From: prev.Year, To: rev.Year}
+	}
+
+	if prev.Runtime != rev.Runtime {
+		changes["runtime"] = FieldChange{From: prev.Runtime, To: rev.Runtime}
+	}
+
+	if !slices.Equal(prev.Genres, rev.Genres) {
+		changes["genres"] = FieldChange{From: prev.Genres, To: rev.Genres}
+	}
+
+	if prev.Status != rev.Status {
+		changes["status"] = FieldChange{From: prev.Status, To: rev.Status}
+	}
+
+	return changes
+}
+
+// records current state of the movie as a revision, has to run in the transaction which changed its version
+func insertRevision(ctx context.Context, tx *sql.Tx, movieID int64, userID int64) error {
+	query := `
+	INSERT INTO movie_revisions(movie_id,version,user_id,title,year,runtime,genres,status)
+	SELECT id,version,$2,title,year,runtime,genres,status FROM movies WHERE id=$1`
+
+	//anonymous user has zero id and doesn't exist in users table
+	var editor *int64
+	if userID > 0 {
+		editor = &userID
+	}
+
+	_, err := tx.ExecContext(ctx, query, movieID, editor)
+
+	return err
+}
+
+type MovieRevisionModel struct {
+	DB *sql.DB
+}
+
+func (m MovieRevisionModel) GetAllForMovie(movieID int64, filters Filters) ([]*MovieRevision, MetaData, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), movie_id,version,created_at,user_id,title,year,runtime,genres,status
+	FROM movie_revisions WHERE movie_id=$1
+	ORDER BY %s %s
+	LIMIT $2 OFFSET $3
+	`, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var rev MovieRevision
+
+		err := rows.Scan(&totalRecords, &rev.MovieID, &rev.Version, &rev.CreatedAt, &rev.UserID, &rev.Title, &rev.Year, &rev.Runtime, pq.Array(&rev.Genres), &rev.Status)
+
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		revisions = append(revisions, &rev)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+func (m MovieRevisionModel) Get(movieID int64, version int32) (*MovieRevision, error) {
+	query := `SELECT movie_id,version,created_at,user_id,title,year,runtime,genres,status FROM movie_revisions WHERE movie_id=$1 AND version=$2`
+
+	return m.getOne(query, movieID, version)
+}
+
+// returns the latest revision saved before the given version
+func (m MovieRevisionModel) GetPrevious(movieID int64, version int32) (*MovieRevision, error) {
+	query := `SELECT movie_id,version,created_at,user_id,title,year,runtime,genres,status FROM movie_revisions WHERE movie_id=$1 AND version<$2 ORDER BY version DESC LIMIT 1`
+
+	return m.getOne(query, movieID, version)
+}
+
+func (m MovieRevisionModel) getOne(query string, movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rev MovieRevision
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(&rev.MovieID, &rev.Version, &rev.CreatedAt, &rev.UserID, &rev.Title, &rev.Year, &rev.Runtime, pq.Array(&rev.Genres), &rev.Status)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &rev, nil
+}
@@ -3,44 +3,216 @@ package data
 import (
 	"errors"
 	"fmt"
+	"math"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
-//can customize the json behavior with Marshaller interface, the marshal looks if the struct satisfies the marshaler interface, if it supports it
-// it uses the MarshalJSON method of the interface to convert the struct to json if not fallback to it's own implementation
-
 var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
 
+const acceptedRuntimeFormats = `minutes as a number (102) or a string such as "102 mins", "102 min", "1h 42m" or "PT1H42M"`
+
+var (
+	runtimeNumberRx  = regexp.MustCompile(`^-?\d+$`)
+	runtimeMinutesRx = regexp.MustCompile(`^(\d+)\s*(?:m|mins?|minutes?)$`)
+	runtimeHoursRx   = regexp.MustCompile(`^(\d+)\s*(?:h|hrs?|hours?)(?:\s*(\d+)\s*(?:m|mins?|minutes?))?$`)
+	runtimeISO8601Rx = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?$`)
+)
+
+// how runtimes are written in responses
+type RuntimeFormat string
+
+const (
+	RuntimeDefault RuntimeFormat = ""        // "102 mins"
+	RuntimeMinutes RuntimeFormat = "minutes" // 102
+	RuntimeISO8601 RuntimeFormat = "iso8601" // "PT1H42M"
+	RuntimeHuman   RuntimeFormat = "human"   // "1h 42m"
+)
+
+var RuntimeFormats = []RuntimeFormat{RuntimeDefault, RuntimeMinutes, RuntimeISO8601, RuntimeHuman}
+
+// runtime input which couldn't be parsed, the message names the problem and the accepted formats
+type RuntimeFormatError struct {
+	Input   string
+	Problem string
+}
+
+func (e *RuntimeFormatError) Error() string {
+	return fmt.Sprintf("runtime %s %s, use %s", e.Input, e.Problem, acceptedRuntimeFormats)
+}
+
+func (e *RuntimeFormatError) Unwrap() error {
+	return ErrInvalidRuntimeFormat
+}
+
 type Runtime int32
 
-func (r Runtime) MarshalJSON() ([]byte, error) {
-	jsonValue := fmt.Sprintf("%d mins", r)
-	quotedJSONValue := strconv.Quote(jsonValue)
+// formats runtime the same way it is represented in json, used by non json exports
+func (r Runtime) String() string {
+	return fmt.Sprintf("%d mins", r)
+}
+
+// formats the runtime as text in the format
+func (r Runtime) Text(format RuntimeFormat) string {
+	hours, minutes := r/60, r%60
+
+	switch format {
+	case RuntimeMinutes:
+		return strconv.Itoa(int(r))
+	case RuntimeISO8601:
+		switch {
+		case r == 0:
+			return "PT0M"
+		case minutes == 0:
+			return fmt.Sprintf("PT%dH", hours)
+		case hours == 0:
+			return fmt.Sprintf("PT%dM", minutes)
+		default:
+			return fmt.Sprintf("PT%dH%dM", hours, minutes)
+		}
+	case RuntimeHuman:
+		switch {
+		case hours == 0:
+			return fmt.Sprintf("%dm", minutes)
+		case minutes == 0:
+			return fmt.Sprintf("%dh", hours)
+		default:
+			return fmt.Sprintf("%dh %dm", hours, minutes)
+		}
+	default:
+		return r.String()
+	}
+}
+
+// formats the runtime as json in the format, minutes are a number and every other format a string
+func (r Runtime) JSON(format RuntimeFormat) []byte {
+	if format == RuntimeMinutes {
+		return []byte(r.Text(format))
+	}
 
-	return []byte(quotedJSONValue), nil
+	return []byte(strconv.Quote(r.Text(format)))
 }
 
+func (r Runtime) MarshalJSON() ([]byte, error) {
+	return r.JSON(RuntimeDefault), nil
+}
+
+// accepts minutes as a json number or any string ParseRuntime accepts
 func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
+	if _, err := strconv.ParseFloat(string(jsonValue), 64); err == nil {
+		runtime, err := ParseRuntime(string(jsonValue))
+
+		if err != nil {
+			return err
+		}
+
+		*r = runtime
+
+		return nil
+	}
+
 	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
 
 	if err != nil {
-		return ErrInvalidRuntimeFormat
+		return &RuntimeFormatError{Input: string(jsonValue), Problem: "must be a number or a string"}
 	}
 
-	parts := strings.Split(unquotedJSONValue, " ")
+	runtime, err := ParseRuntime(unquotedJSONValue)
 
-	if len(parts) != 2 || parts[1] != "mins" {
-		return ErrInvalidRuntimeFormat
+	if err != nil {
+		return err
+	}
+
+	*r = runtime
+
+	return nil
+}
+
+// parses a runtime given as minutes ("102", "102 mins", "102 min", "102m"), hours and minutes ("1h 42m", "2h")
+// or an ISO 8601 duration ("PT1H42M"), runtimes are whole minutes so seconds must add up to whole minutes
+func ParseRuntime(s string) (Runtime, error) {
+	input := strconv.Quote(s)
+	s = strings.TrimSpace(s)
+
+	if s == "" {
+		return 0, &RuntimeFormatError{Input: input, Problem: "must not be empty"}
 	}
 
-	i, err := strconv.ParseInt(parts[0], 10, 32)
+	var minutes, seconds int64
+	var err error
+
+	switch upper := strings.ToUpper(s); {
+	case strings.HasPrefix(upper, "P"):
+		match := runtimeISO8601Rx.FindStringSubmatch(upper)
+
+		if match == nil || upper == "PT" {
+			return 0, &RuntimeFormatError{Input: input, Problem: "is not an ISO 8601 duration of hours, minutes and seconds"}
+		}
+
+		minutes, err = sumParts(60, match[1], match[2])
+
+		if err == nil && match[3] != "" {
+			seconds, err = sumParts(1, match[3])
+		}
+	default:
+		lower := strings.ToLower(s)
+
+		if match := runtimeMinutesRx.FindStringSubmatch(lower); match != nil {
+			minutes, err = sumParts(1, match[1])
+		} else if match := runtimeHoursRx.FindStringSubmatch(lower); match != nil {
+			minutes, err = sumParts(60, match[1], match[2])
+		} else if runtimeNumberRx.MatchString(lower) || strings.HasPrefix(lower, "-") {
+			minutes, err = sumParts(1, lower)
+		} else if strings.ContainsAny(lower, ".,") {
+			return 0, &RuntimeFormatError{Input: input, Problem: "must be a whole number of hours and minutes"}
+		} else {
+			return 0, &RuntimeFormatError{Input: input, Problem: "is not a recognised format"}
+		}
+	}
 
 	if err != nil {
-		return ErrInvalidRuntimeFormat
+		return 0, &RuntimeFormatError{Input: input, Problem: err.Error()}
 	}
 
-	*r = Runtime(i)
+	if seconds%60 != 0 {
+		return 0, &RuntimeFormatError{Input: input, Problem: "must be whole minutes"}
+	}
 
-	return nil
+	minutes += seconds / 60
+
+	if minutes > math.MaxInt32 {
+		return 0, &RuntimeFormatError{Input: input, Problem: "is too long"}
+	}
+
+	return Runtime(minutes), nil
+}
+
+// adds up the numeric parts of a runtime, the first one is worth unit and any other one is counted as is
+func sumParts(unit int64, parts ...string) (int64, error) {
+	var total int64
+
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+
+		if strings.HasPrefix(part, "-") {
+			return 0, errors.New("must not be negative")
+		}
+
+		n, err := strconv.ParseInt(part, 10, 32)
+
+		if err != nil {
+			return 0, errors.New("is too long")
+		}
+
+		if i == 0 {
+			n *= unit
+		}
+
+		total += n
+	}
+
+	return total, nil
 }
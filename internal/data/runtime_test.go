@@ -0,0 +1,174 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseRuntime(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Runtime
+		err   string // the whole message, empty when the input is accepted
+	}{
+		{"102", 102, ""},
+		{" 102 ", 102, ""},
+		{"0", 0, ""},
+		{"102 mins", 102, ""},
+		{"102 min", 102, ""},
+		{"102mins", 102, ""},
+		{"102m", 102, ""},
+		{"102 minutes", 102, ""},
+		{"102 MINS", 102, ""},
+		{"1h 42m", 102, ""},
+		{"1h42m", 102, ""},
+		{"1 hr 42 min", 102, ""},
+		{"1 hour 42 minutes", 102, ""},
+		{"2h", 120, ""},
+		{"2 hrs", 120, ""},
+		{"0h 5m", 5, ""},
+		{"1h 90m", 150, ""},
+		{"PT1H42M", 102, ""},
+		{"pt1h42m", 102, ""},
+		{"PT2H", 120, ""},
+		{"PT42M", 42, ""},
+		{"PT120S", 2, ""},
+		{"PT1H30M60S", 91, ""},
+		{"PT0M", 0, ""},
+		{"", 0, `runtime "" must not be empty, use ` + acceptedRuntimeFormats},
+		{"   ", 0, `runtime "   " must not be empty, use ` + acceptedRuntimeFormats},
+		{"PT90S", 0, `runtime "PT90S" must be whole minutes, use ` + acceptedRuntimeFormats},
+		{"PT", 0, `runtime "PT" is not an ISO 8601 duration of hours, minutes and seconds, use ` + acceptedRuntimeFormats},
+		{"P1D", 0, `runtime "P1D" is not an ISO 8601 duration of hours, minutes and seconds, use ` + acceptedRuntimeFormats},
+		{"PT1.5H", 0, `runtime "PT1.5H" is not an ISO 8601 duration of hours, minutes and seconds, use ` + acceptedRuntimeFormats},
+		{"102.5", 0, `runtime "102.5" must be a whole number of hours and minutes, use ` + acceptedRuntimeFormats},
+		{"1.5h", 0, `runtime "1.5h" must be a whole number of hours and minutes, use ` + acceptedRuntimeFormats},
+		{"-5", 0, `runtime "-5" must not be negative, use ` + acceptedRuntimeFormats},
+		{"-5 mins", 0, `runtime "-5 mins" must not be negative, use ` + acceptedRuntimeFormats},
+		{"1h 42", 0, `runtime "1h 42" is not a recognised format, use ` + acceptedRuntimeFormats},
+		{"102 secs", 0, `runtime "102 secs" is not a recognised format, use ` + acceptedRuntimeFormats},
+		{"abc", 0, `runtime "abc" is not a recognised format, use ` + acceptedRuntimeFormats},
+		{"2147483647", 2147483647, ""},
+		{"2147483648", 0, `runtime "2147483648" is too long, use ` + acceptedRuntimeFormats},
+		{"99999999999999999999", 0, `runtime "99999999999999999999" is too long, use ` + acceptedRuntimeFormats},
+		{"35791395h", 0, `runtime "35791395h" is too long, use ` + acceptedRuntimeFormats},
+		{"PT99999999999999999999S", 0, `runtime "PT99999999999999999999S" is too long, use ` + acceptedRuntimeFormats},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseRuntime(tt.input)
+
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if got != tt.want {
+					t.Errorf("got %d, want %d", got, tt.want)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("got %d, want error %q", got, tt.err)
+			}
+
+			if err.Error() != tt.err {
+				t.Errorf("error %q\nwant %q", err, tt.err)
+			}
+
+			if !errors.Is(err, ErrInvalidRuntimeFormat) {
+				t.Errorf("error %v doesn't wrap ErrInvalidRuntimeFormat", err)
+			}
+		})
+	}
+}
+
+func TestRuntimeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Runtime
+		err   string
+	}{
+		{`102`, 102, ""},
+		{`"102"`, 102, ""},
+		{`"102 mins"`, 102, ""},
+		{`"1h 42m"`, 102, ""},
+		{`"PT1H42M"`, 102, ""},
+		{`102.5`, 0, `runtime "102.5" must be a whole number of hours and minutes, use ` + acceptedRuntimeFormats},
+		{`-5`, 0, `runtime "-5" must not be negative, use ` + acceptedRuntimeFormats},
+		{`1e2`, 0, `runtime "1e2" is not a recognised format, use ` + acceptedRuntimeFormats},
+		{`"PT90S"`, 0, `runtime "PT90S" must be whole minutes, use ` + acceptedRuntimeFormats},
+		{`3000000000`, 0, `runtime "3000000000" is too long, use ` + acceptedRuntimeFormats},
+		{`true`, 0, `runtime true must be a number or a string, use ` + acceptedRuntimeFormats},
+		{`[102]`, 0, `runtime [102] must be a number or a string, use ` + acceptedRuntimeFormats},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var input struct {
+				Runtime Runtime `json:"runtime"`
+			}
+
+			err := json.Unmarshal([]byte(`{"runtime":`+tt.input+`}`), &input)
+
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if input.Runtime != tt.want {
+					t.Errorf("got %d, want %d", input.Runtime, tt.want)
+				}
+
+				return
+			}
+
+			var formatErr *RuntimeFormatError
+
+			if !errors.As(err, &formatErr) {
+				t.Fatalf("error %v, want a RuntimeFormatError", err)
+			}
+
+			if err.Error() != tt.err {
+				t.Errorf("error %q\nwant %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRuntimeFormats(t *testing.T) {
+	tests := []struct {
+		runtime Runtime
+		format  RuntimeFormat
+		want    string
+	}{
+		{102, RuntimeDefault, `"102 mins"`},
+		{102, RuntimeMinutes, `102`},
+		{102, RuntimeISO8601, `"PT1H42M"`},
+		{102, RuntimeHuman, `"1h 42m"`},
+		{120, RuntimeISO8601, `"PT2H"`},
+		{120, RuntimeHuman, `"2h"`},
+		{42, RuntimeISO8601, `"PT42M"`},
+		{42, RuntimeHuman, `"42m"`},
+		{0, RuntimeISO8601, `"PT0M"`},
+	}
+
+	for _, tt := range tests {
+		got := string(tt.runtime.JSON(tt.format))
+
+		if got != tt.want {
+			t.Errorf("%d in %q: got %s, want %s", tt.runtime, tt.format, got, tt.want)
+		}
+
+		//every format is accepted back as input
+		var parsed Runtime
+
+		if err := json.Unmarshal([]byte(got), &parsed); err != nil || parsed != tt.runtime {
+			t.Errorf("%s parsed as %d, %v", got, parsed, err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// aggregates over the movies matching a search
+type MovieStats struct {
+	Total        int64         `json:"total"`
+	Genres       []GenreStats  `json:"genres"`
+	Decades      []DecadeStats `json:"decades"`
+	AddedPerWeek []WeekStats   `json:"added_per_week"` // oldest week first, weeks without additions included
+	GeneratedAt  time.Time     `json:"generated_at"`
+}
+
+type GenreStats struct {
+	Genre          string  `json:"genre"`
+	Count          int64   `json:"count"`
+	AverageRuntime float64 `json:"average_runtime"` // minutes
+}
+
+type DecadeStats struct {
+	Decade int32 `json:"decade"` // first year of the decade, e.g. 1990
+	Count  int64 `json:"count"`
+}
+
+type WeekStats struct {
+	Week  string `json:"week"` // monday the week starts on
+	Count int64  `json:"count"`
+}
+
+type StatsModel struct {
+	DB *sql.DB
+}
+
+// computes the aggregates of the movies matching the search, movies added per week cover the last weeks weeks
+// every aggregate is read from the same snapshot, so they add up
+func (m StatsModel) GetMovieStats(search MovieSearch, weeks int) (*MovieStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	where, args := search.clause()
+	filtered := fmt.Sprintf(`WITH filtered AS (SELECT id, year, runtime, genres, created_at FROM movies WHERE %s)`, where)
+
+	stats := &MovieStats{
+		Genres:       []GenreStats{},
+		Decades:      []DecadeStats{},
+		AddedPerWeek: []WeekStats{},
+		GeneratedAt:  time.Now().UTC(),
+	}
+
+	err = tx.QueryRowContext(ctx, filtered+` SELECT count(*) FROM filtered`, args...).Scan(&stats.Total)
+
+	if err != nil {
+		return nil, err
+	}
+
+	query := filtered + `
+	SELECT genre, count(*), avg(runtime)::float8
+	FROM filtered, unnest(genres) AS genre
+	GROUP BY genre
+	ORDER BY count(*) DESC, genre`
+
+	err = scanStats(ctx, tx, query, args, func(rows *sql.Rows) error {
+		var genre GenreStats
+
+		err := rows.Scan(&genre.Genre, &genre.Count, &genre.AverageRuntime)
+		stats.Genres = append(stats.Genres, genre)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	query = filtered + `
+	SELECT year / 10 * 10 AS decade, count(*)
+	FROM filtered
+	GROUP BY decade
+	ORDER BY decade`
+
+	err = scanStats(ctx, tx, query, args, func(rows *sql.Rows) error {
+		var decade DecadeStats
+
+		err := rows.Scan(&decade.Decade, &decade.Count)
+		stats.Decades = append(stats.Decades, decade)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	query = filtered + fmt.Sprintf(`
+	SELECT week::date::text, count(filtered.id)
+	FROM generate_series(date_trunc('week', now()) - ($%d - 1) * interval '1 week', date_trunc('week', now()), interval '1 week') AS week
+	LEFT JOIN filtered ON date_trunc('week', filtered.created_at) = week
+	GROUP BY week
+	ORDER BY week`, len(args)+1)
+
+	err = scanStats(ctx, tx, query, append(args, weeks), func(rows *sql.Rows) error {
+		var week WeekStats
+
+		err := rows.Scan(&week.Week, &week.Count)
+		stats.AddedPerWeek = append(stats.AddedPerWeek, week)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, tx.Commit()
+}
+
+// runs the query and calls scan for every row
+func scanStats(ctx context.Context, tx *sql.Tx, query string, args []any, scan func(*sql.Rows) error) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		err := scan(rows)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,294 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"movies.samkha.net/internal/validator"
+)
+
+// postgres text search configurations by language, languages without one use 'simple'
+var searchConfigs = map[string]string{
+	"ar": "arabic",
+	"da": "danish",
+	"de": "german",
+	"el": "greek",
+	"en": "english",
+	"es": "spanish",
+	"fi": "finnish",
+	"fr": "french",
+	"ga": "irish",
+	"hu": "hungarian",
+	"id": "indonesian",
+	"it": "italian",
+	"lt": "lithuanian",
+	"nb": "norwegian",
+	"ne": "nepali",
+	"nl": "dutch",
+	"nn": "norwegian",
+	"no": "norwegian",
+	"pt": "portuguese",
+	"ro": "romanian",
+	"ru": "russian",
+	"sv": "swedish",
+	"ta": "tamil",
+	"tr": "turkish",
+}
+
+// title and synopsis of a movie in another language
+type Translation struct {
+	Locale   string `json:"locale"`
+	Title    string `json:"title"`
+	Synopsis string `json:"synopsis,omitempty"`
+}
+
+// brings a language tag such as "pt_br" into its canonical form "pt-BR"
+// tags are a language optionally followed by a script and a region, e.g. "de", "zh-Hant-TW" or "es-419"
+func NormaliseLocale(tag string) (string, bool) {
+	parts := strings.FieldsFunc(strings.TrimSpace(tag), func(r rune) bool { return r == '-' || r == '_' })
+
+	if len(parts) == 0 || len(parts) > 3 || !isAlpha(parts[0]) || len(parts[0]) < 2 || len(parts[0]) > 3 {
+		return "", false
+	}
+
+	normalised := []string{strings.ToLower(parts[0])}
+	rest := parts[1:]
+
+	if len(rest) > 0 && len(rest[0]) == 4 && isAlpha(rest[0]) {
+		normalised = append(normalised, strings.ToUpper(rest[0][:1])+strings.ToLower(rest[0][1:]))
+		rest = rest[1:]
+	}
+
+	if len(rest) > 0 {
+		region := rest[0]
+
+		switch {
+		case len(region) == 2 && isAlpha(region):
+			normalised = append(normalised, strings.ToUpper(region))
+		case len(region) == 3 && strings.Trim(region, "0123456789") == "":
+			normalised = append(normalised, region)
+		default:
+			return "", false
+		}
+
+		rest = rest[1:]
+	}
+
+	if len(rest) > 0 {
+		return "", false
+	}
+
+	return strings.Join(normalised, "-"), true
+}
+
+func isAlpha(s string) bool {
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// text search configuration for the language of the locale
+func SearchConfig(locale string) string {
+	language, _, _ := strings.Cut(locale, "-")
+
+	if config, ok := searchConfigs[language]; ok {
+		return config
+	}
+
+	return "simple"
+}
+
+func ValidateTranslation(v *validator.Validator, translation *Translation) {
+	_, ok := NormaliseLocale(translation.Locale)
+
+	v.Check(ok, "locale", "must be a language tag such as en, de-CH or pt-BR")
+	v.Check(validator.NotBlank(translation.Title), "title", "must be provided")
+	v.Check(len(translation.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(validator.MaxChars(translation.Synopsis, 5000), "synopsis", "must not be more than 5000 characters long")
+	v.Check(validator.PrintableText(translation.Synopsis), "synopsis", "must not contain control characters")
+}
+
+type TranslationModel struct {
+	DB *sql.DB
+}
+
+func (m TranslationModel) GetAllForMovie(movieID int64) ([]*Translation, error) {
+	query := `SELECT locale,title,synopsis FROM movie_translations WHERE movie_id=$1 ORDER BY locale`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	translations := []*Translation{}
+
+	for rows.Next() {
+		var translation Translation
+
+		err := rows.Scan(&translation.Locale, &translation.Title, &translation.Synopsis)
+
+		if err != nil {
+			return nil, err
+		}
+
+		translations = append(translations, &translation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return translations, nil
+}
+
+// adds or replaces the translation and bumps the movie version, reporting whether the translation is new
+func (m TranslationModel) Upsert(movieID int64, translation *Translation, userID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	err = bumpMovieVersion(ctx, tx, &Movie{ID: movieID}, userID)
+
+	if err != nil {
+		return false, err
+	}
+
+	query := `
+	INSERT INTO movie_translations(movie_id,locale,title,synopsis,search_config) VALUES($1,$2,$3,$4,$5::regconfig)
+	ON CONFLICT (movie_id,locale) DO UPDATE SET title=EXCLUDED.title,synopsis=EXCLUDED.synopsis,search_config=EXCLUDED.search_config
+	RETURNING xmax = 0`
+
+	var created bool
+
+	err = tx.QueryRowContext(ctx, query, movieID, translation.Locale, translation.Title, translation.Synopsis, SearchConfig(translation.Locale)).Scan(&created)
+
+	if err != nil {
+		return false, err
+	}
+
+	return created, tx.Commit()
+}
+
+func (m TranslationModel) Delete(movieID int64, locale string, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = bumpMovieVersion(ctx, tx, &Movie{ID: movieID}, userID)
+
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM movie_translations WHERE movie_id=$1 AND locale=$2`, movieID, locale)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// shows the movies in the best matching of the preferred locales, movies without a match keep their original title
+// a translation matches a locale exactly or by its language, so "pt-PT" is picked for "pt" when there is no plain "pt"
+func (m TranslationModel) Localise(locales []string, movies ...*Movie) error {
+	if len(locales) == 0 || len(movies) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(movies))
+
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	query := `
+	SELECT DISTINCT ON (movie_id) movie_id, locale, title, synopsis
+	FROM (
+		SELECT movie_id, locale, title, synopsis,
+		COALESCE(array_position($2::text[], locale), array_position($2::text[], split_part(locale, '-', 1))) AS rank
+		FROM movie_translations WHERE movie_id = ANY($1)
+	) AS candidates
+	WHERE rank IS NOT NULL
+	ORDER BY movie_id, rank, locale`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids), pq.Array(locales))
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	byID := make(map[int64][]*Movie, len(movies))
+
+	for _, movie := range movies {
+		byID[movie.ID] = append(byID[movie.ID], movie)
+	}
+
+	for rows.Next() {
+		var (
+			movieID     int64
+			translation Translation
+		)
+
+		err := rows.Scan(&movieID, &translation.Locale, &translation.Title, &translation.Synopsis)
+
+		if err != nil {
+			return err
+		}
+
+		for _, movie := range byID[movieID] {
+			//localising twice must not lose the original title
+			if movie.OriginalTitle == "" {
+				movie.OriginalTitle = movie.Title
+			}
+
+			movie.Title = translation.Title
+			movie.Synopsis = translation.Synopsis
+			movie.Locale = translation.Locale
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,162 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type WatchlistModel struct {
+	DB *sql.DB
+}
+
+// checks the movie exists and isn't in the trash, so it can be put on a list
+func movieExists(ctx context.Context, db *sql.DB, movieID int64) error {
+	var exists bool
+
+	err := db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM movies WHERE id=$1 AND deleted_at IS NULL)`, movieID).Scan(&exists)
+
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// adding a movie which already is on the watchlist is a no-op
+func (m WatchlistModel) Add(userID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := movieExists(ctx, m.DB, movieID)
+
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO watchlist(user_id,movie_id) VALUES($1,$2) ON CONFLICT DO NOTHING`
+
+	_, err = m.DB.ExecContext(ctx, query, userID, movieID)
+
+	return err
+}
+
+func (m WatchlistModel) Remove(userID, movieID int64) error {
+	return m.deleteEntry(`DELETE FROM watchlist WHERE user_id=$1 AND movie_id=$2`, userID, movieID)
+}
+
+// records when the user watched the movie, marking it again replaces the date
+func (m WatchlistModel) MarkWatched(userID, movieID int64, watchedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := movieExists(ctx, m.DB, movieID)
+
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO watched(user_id,movie_id,watched_at) VALUES($1,$2,$3)
+	ON CONFLICT (user_id,movie_id) DO UPDATE SET watched_at=EXCLUDED.watched_at`
+
+	_, err = m.DB.ExecContext(ctx, query, userID, movieID, watchedAt)
+
+	return err
+}
+
+func (m WatchlistModel) UnmarkWatched(userID, movieID int64) error {
+	return m.deleteEntry(`DELETE FROM watched WHERE user_id=$1 AND movie_id=$2`, userID, movieID)
+}
+
+func (m WatchlistModel) deleteEntry(query string, userID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// sets InWatchlist and WatchedAt of the movies from the point of view of the user
+func (m WatchlistModel) Annotate(userID int64, movies ...*Movie) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(movies))
+
+	for i, movie := range movies {
+		ids[i] = movie.ID
+		inWatchlist := false
+		movie.InWatchlist = &inWatchlist
+	}
+
+	query := `
+	SELECT movies.id, watchlist.movie_id IS NOT NULL, watched.watched_at
+	FROM unnest($2::bigint[]) AS movies(id)
+	LEFT JOIN watchlist ON watchlist.movie_id = movies.id AND watchlist.user_id = $1
+	LEFT JOIN watched ON watched.movie_id = movies.id AND watched.user_id = $1
+	WHERE watchlist.movie_id IS NOT NULL OR watched.movie_id IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, pq.Array(ids))
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	byID := make(map[int64][]*Movie, len(movies))
+
+	for _, movie := range movies {
+		byID[movie.ID] = append(byID[movie.ID], movie)
+	}
+
+	for rows.Next() {
+		var (
+			id          int64
+			inWatchlist bool
+			watchedAt   sql.NullTime
+		)
+
+		err := rows.Scan(&id, &inWatchlist, &watchedAt)
+
+		if err != nil {
+			return err
+		}
+
+		for _, movie := range byID[id] {
+			*movie.InWatchlist = inWatchlist
+
+			if watchedAt.Valid {
+				movie.WatchedAt = &watchedAt.Time
+			}
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,519 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+	"movies.samkha.net/internal/validator"
+)
+
+// events webhooks can subscribe to, movie events mirror the operations of the change log
+const (
+	EventMovieCreated  = "movie.created"
+	EventMovieUpdated  = "movie.updated"
+	EventMovieDeleted  = "movie.deleted"
+	EventUserActivated = "user.activated"
+)
+
+var WebhookEvents = []string{EventMovieCreated, EventMovieUpdated, EventMovieDeleted, EventUserActivated}
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed" // gave up after MaxDeliveryAttempts, can still be redelivered manually
+)
+
+// attempts before a delivery fails, with the backoff doubling from 30 seconds they span about four hours
+const MaxDeliveryAttempts = 10
+
+// time a claimed delivery is reserved for the attempt, it's retried after that if the attempt is never recorded
+const deliveryLease = 2 * time.Minute
+
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// body of every delivery, signed with the webhook's secret
+type WebhookEvent struct {
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+type WebhookDelivery struct {
+	ID            int64             `json:"id"`
+	WebhookID     int64             `json:"webhook_id"`
+	Event         string            `json:"event"`
+	Payload       json.RawMessage   `json:"payload"`
+	Status        string            `json:"status"`
+	Attempts      int               `json:"attempts"` // since the delivery was created or last redelivered
+	NextAttemptAt *time.Time        `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Log           []*WebhookAttempt `json:"log,omitempty"` // only loaded when showing a single delivery
+	URL           string            `json:"-"`
+	Secret        string            `json:"-"`
+}
+
+type WebhookAttempt struct {
+	AttemptedAt    time.Time `json:"attempted_at"`
+	DurationMS     int64     `json:"duration_ms"`
+	ResponseStatus *int      `json:"response_status"` // nil when no response was received
+	ResponseBody   string    `json:"response_body,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// wait before the attempt following the given number of failed attempts
+func deliveryBackoff(attempts int) time.Duration {
+	return 30 * time.Second << min(attempts-1, 20)
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	u, err := url.Parse(webhook.URL)
+
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(validator.MaxChars(webhook.URL, 2048), "url", "must not be more than 2048 characters long")
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https url")
+
+	v.Check(len(webhook.Events) > 0, "events", "must contain at least one event")
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate values")
+
+	for _, event := range webhook.Events {
+		if !validator.PermittedValue(event, WebhookEvents...) {
+			v.AddError("events", fmt.Sprintf("%q is not one of movie.created, movie.updated, movie.deleted or user.activated", event))
+		}
+	}
+
+	v.Check(validator.MinChars(webhook.Secret, 16), "secret", "must be at least 16 characters long")
+	v.Check(validator.MaxChars(webhook.Secret, 256), "secret", "must not be more than 256 characters long")
+}
+
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	query := `INSERT INTO webhooks(url,events,secret) VALUES($1,$2,$3) RETURNING id,created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, webhook.URL, pq.Array(webhook.Events), webhook.Secret).Scan(&webhook.ID, &webhook.CreatedAt)
+}
+
+func (m WebhookModel) GetAll(filters Filters) ([]*Webhook, MetaData, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id,url,events,created_at
+	FROM webhooks
+	ORDER BY %s %s, id ASC
+	LIMIT $1 OFFSET $2`, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+
+		err := rows.Scan(&totalRecords, &webhook.ID, &webhook.URL, pq.Array(&webhook.Events), &webhook.CreatedAt)
+
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	return webhooks, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func (m WebhookModel) Get(id int64) (*Webhook, error) {
+	query := `SELECT id,url,events,created_at FROM webhooks WHERE id=$1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var webhook Webhook
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&webhook.ID, &webhook.URL, pq.Array(&webhook.Events), &webhook.CreatedAt)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &webhook, nil
+}
+
+// deletes the webhook together with its deliveries
+func (m WebhookModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM webhooks WHERE id=$1`, id)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// queues a delivery of the event to every webhook subscribed to it
+func (m WebhookModel) Enqueue(event string, data any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return enqueueDeliveries(ctx, m.DB, event, time.Now(), data)
+}
+
+// anything deliveries can be queued through, a transaction or the pool
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func enqueueDeliveries(ctx context.Context, db execer, event string, occurredAt time.Time, data any) error {
+	payload, err := json.Marshal(WebhookEvent{Event: event, OccurredAt: occurredAt, Data: data})
+
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO webhook_deliveries(webhook_id,event,payload)
+	SELECT id,$1,$2 FROM webhooks WHERE $1 = ANY(events)
+	ORDER BY id`
+
+	_, err = db.ExecContext(ctx, query, event, string(payload))
+
+	return err
+}
+
+// queues deliveries of up to limit movie changes recorded since the last call, returns the number of changes dispatched
+// the dispatch cursor is locked for the transaction so concurrent calls never dispatch a change twice
+func (m WebhookModel) DispatchChanges(limit int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	var cursor int64
+
+	err = tx.QueryRowContext(ctx, `SELECT last_sequence FROM webhook_dispatch FOR UPDATE`).Scan(&cursor)
+
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+	SELECT sequence,operation,movie_id,version,changed_at,movie
+	FROM movie_changes WHERE sequence > $1
+	ORDER BY sequence
+	LIMIT $2`
+
+	rows, err := tx.QueryContext(ctx, query, cursor, limit)
+
+	if err != nil {
+		return 0, err
+	}
+
+	changes, err := scanChanges(rows)
+	rows.Close()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(changes) == 0 {
+		return 0, nil
+	}
+
+	for _, change := range changes {
+		err = enqueueDeliveries(ctx, tx, ChangeEvents[change.Operation], change.ChangedAt, change)
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE webhook_dispatch SET last_sequence=$1`, changes[len(changes)-1].Sequence)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(changes), tx.Commit()
+}
+
+// reserves up to limit pending deliveries which are due for an attempt, deliveries claimed by another
+// instance are skipped and a claimed delivery is due again once its lease runs out
+func (m WebhookModel) ClaimDue(limit int) ([]*WebhookDelivery, error) {
+	query := `
+	UPDATE webhook_deliveries d SET next_attempt_at = NOW() + $1 * interval '1 second'
+	FROM webhooks w
+	WHERE w.id = d.webhook_id AND d.id IN (
+		SELECT id FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at, id
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING d.id,d.webhook_id,d.event,d.payload,d.status,d.attempts,d.created_at,w.url,w.secret`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, int(deliveryLease.Seconds()), limit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+
+		err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Payload, &delivery.Status, &delivery.Attempts, &delivery.CreatedAt, &delivery.URL, &delivery.Secret)
+
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// logs the attempt and settles the delivery, a failed attempt schedules the next one with exponential backoff
+// until MaxDeliveryAttempts is reached, the delivery is updated with the outcome
+func (m WebhookModel) RecordAttempt(delivery *WebhookDelivery, attempt *WebhookAttempt, succeeded bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `INSERT INTO webhook_attempts(delivery_id,attempted_at,duration_ms,response_status,response_body,error) VALUES($1,$2,$3,$4,$5,$6)`
+
+	_, err = tx.ExecContext(ctx, query, delivery.ID, attempt.AttemptedAt, attempt.DurationMS, attempt.ResponseStatus, attempt.ResponseBody, attempt.Error)
+
+	if err != nil {
+		return err
+	}
+
+	delivery.Attempts++
+	delivery.NextAttemptAt = nil
+
+	switch {
+	case succeeded:
+		delivery.Status = DeliverySucceeded
+	case delivery.Attempts >= MaxDeliveryAttempts:
+		delivery.Status = DeliveryFailed
+	default:
+		next := time.Now().Add(deliveryBackoff(delivery.Attempts))
+		delivery.Status = DeliveryPending
+		delivery.NextAttemptAt = &next
+	}
+
+	query = `UPDATE webhook_deliveries SET status=$1, attempts=$2, next_attempt_at=COALESCE($3, next_attempt_at) WHERE id=$4`
+
+	_, err = tx.ExecContext(ctx, query, delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.ID)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deliveries of the webhook, newest first, optionally only those with the status
+func (m WebhookModel) GetDeliveries(webhookID int64, status string, filters Filters) ([]*WebhookDelivery, MetaData, error) {
+	query := `
+	SELECT count(*) OVER(), id,webhook_id,event,payload,status,attempts,next_attempt_at,created_at
+	FROM webhook_deliveries
+	WHERE webhook_id=$1 AND (status=$2 OR $2='')
+	ORDER BY id DESC
+	LIMIT $3 OFFSET $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, status, filters.limit(), filters.offset())
+
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+
+		err := rows.Scan(&totalRecords, &delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Payload, &delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt, &delivery.CreatedAt)
+
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		delivery.settle()
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	return deliveries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// only pending deliveries have a next attempt
+func (d *WebhookDelivery) settle() {
+	if d.Status != DeliveryPending {
+		d.NextAttemptAt = nil
+	}
+}
+
+// the delivery with the log of its attempts, oldest first
+func (m WebhookModel) GetDelivery(webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	query := `
+	SELECT id,webhook_id,event,payload,status,attempts,next_attempt_at,created_at
+	FROM webhook_deliveries WHERE id=$1 AND webhook_id=$2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var delivery WebhookDelivery
+
+	err := m.DB.QueryRowContext(ctx, query, deliveryID, webhookID).Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Payload, &delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt, &delivery.CreatedAt)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	delivery.settle()
+
+	query = `
+	SELECT attempted_at,duration_ms,response_status,response_body,error
+	FROM webhook_attempts WHERE delivery_id=$1
+	ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, delivery.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	delivery.Log = []*WebhookAttempt{}
+
+	for rows.Next() {
+		var attempt WebhookAttempt
+
+		err := rows.Scan(&attempt.AttemptedAt, &attempt.DurationMS, &attempt.ResponseStatus, &attempt.ResponseBody, &attempt.Error)
+
+		if err != nil {
+			return nil, err
+		}
+
+		delivery.Log = append(delivery.Log, &attempt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// queues the delivery again with a fresh set of attempts and claims it for an immediate attempt
+// receivers get a delivery at least once, so one being attempted right now may arrive twice
+func (m WebhookModel) Redeliver(webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	query := `
+	UPDATE webhook_deliveries d SET status='pending', attempts=0, next_attempt_at = NOW() + $1 * interval '1 second'
+	FROM webhooks w
+	WHERE w.id = d.webhook_id AND d.id=$2 AND d.webhook_id=$3
+	RETURNING d.id,d.webhook_id,d.event,d.payload,d.status,d.attempts,d.created_at,w.url,w.secret`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var delivery WebhookDelivery
+
+	err := m.DB.QueryRowContext(ctx, query, int(deliveryLease.Seconds()), deliveryID, webhookID).Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Payload, &delivery.Status, &delivery.Attempts, &delivery.CreatedAt, &delivery.URL, &delivery.Secret)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &delivery, nil
+}
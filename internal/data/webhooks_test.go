@@ -0,0 +1,49 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// records the arguments of the statements instead of running them
+type recordingExecer struct {
+	args [][]any
+}
+
+func (e *recordingExecer) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	e.args = append(e.args, args)
+	return nil, nil
+}
+
+func TestMovieEventPayload(t *testing.T) {
+	occurredAt := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	//a rated movie, its ratings aren't part of the change log and must not be delivered as zero
+	movie, err := decodeMovieState([]byte(`{"id": 7, "year": 1994, "title": "Pulp Fiction", "genres": ["crime"], "status": "released", "runtime": 154, "version": 3}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	change := &MovieChange{Sequence: 12, Operation: ChangeUpdate, MovieID: 7, Version: 3, ChangedAt: occurredAt, Movie: movie}
+
+	db := &recordingExecer{}
+
+	err = enqueueDeliveries(context.Background(), db, ChangeEvents[change.Operation], change.ChangedAt, change)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(db.args) != 1 || len(db.args[0]) != 2 {
+		t.Fatalf("got statements with arguments %v", db.args)
+	}
+
+	want := `{"event":"movie.updated","occurred_at":"2024-03-01T12:00:00Z","data":{"sequence":12,"operation":"update","movie_id":7,"version":3,"changed_at":"2024-03-01T12:00:00Z","movie":{"id":7,"title":"Pulp Fiction","year":1994,"runtime":"154 mins","Genres":["crime"],"version":3,"status":"released"}}}`
+
+	if got := db.args[0][1]; got != want {
+		t.Errorf("payload\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,67 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+)
+
+// scales the image down to the given width keeping its aspect ratio, images which are narrower already are copied as is
+// every target pixel is the average of the source pixels it covers, which keeps downscaled images free of aliasing
+// transparent areas are flattened onto white, as thumbnails are encoded as jpeg
+func Resize(src image.Image, width int) *image.RGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	if width <= 0 || width > srcW {
+		width = srcW
+	}
+
+	height := max(1, (srcH*width+srcW/2)/srcW)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcH/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*srcH/height)
+
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcW/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*srcW/width)
+
+			var r, g, b, n uint64
+
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					//colors are alpha premultiplied, adding the missing coverage as white flattens onto white
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr + 0xffff - ca)
+					g += uint64(cg + 0xffff - ca)
+					b += uint64(cb + 0xffff - ca)
+					n++
+				}
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	return dst
+}
+
+func EncodeJPEG(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,347 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// returned, wrapped, when a test operation doesn't match the document
+var ErrTestFailed = errors.New("test failed")
+
+// operation of a json patch (RFC 6902), of which add, remove, replace and test are supported
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+	From  string          `json:"from"`
+}
+
+// applies a json merge patch (RFC 7396) to the document
+// members of patch objects replace those of the document, null members are removed and anything but an object replaces the document
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := decode(patch)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergePatch(target, p))
+}
+
+func mergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+
+	if !ok {
+		targetObject = map[string]any{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+
+	return targetObject
+}
+
+// applies the operations in order, the document is left as it was when any of them fails
+func Apply(doc []byte, ops []Operation) ([]byte, error) {
+	root, err := decode(doc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		root, err = apply(root, op)
+
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+
+	return json.Marshal(root)
+}
+
+func apply(root any, op Operation) (any, error) {
+	tokens, err := parsePointer(op.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, errors.New("value must be provided")
+		}
+
+		value, err = decode(op.Value)
+
+		if err != nil {
+			return nil, err
+		}
+	case "remove":
+	case "move", "copy":
+		return nil, fmt.Errorf("operation %q is not supported", op.Op)
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op.Op)
+	}
+
+	if op.Op == "test" {
+		current, err := get(root, tokens)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !equal(current, value) {
+			return nil, ErrTestFailed
+		}
+
+		return root, nil
+	}
+
+	//operations on the whole document replace it
+	if len(tokens) == 0 {
+		switch op.Op {
+		case "remove":
+			return nil, errors.New("the whole document can't be removed")
+		default:
+			return value, nil
+		}
+	}
+
+	parent, err := get(root, tokens[:len(tokens)-1])
+
+	if err != nil {
+		return nil, err
+	}
+
+	key := tokens[len(tokens)-1]
+
+	switch container := parent.(type) {
+	case map[string]any:
+		if _, exists := container[key]; !exists && op.Op != "add" {
+			return nil, fmt.Errorf("member %q does not exist", key)
+		}
+
+		if op.Op == "remove" {
+			delete(container, key)
+		} else {
+			container[key] = value
+		}
+
+		return root, nil
+	case []any:
+		index, err := arrayIndex(key, len(container), op.Op == "add")
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.Op {
+		case "add":
+			container = append(container[:index], append([]any{value}, container[index:]...)...)
+		case "remove":
+			container = append(container[:index], container[index+1:]...)
+		case "replace":
+			container[index] = value
+		}
+
+		//the array may have been reallocated, so it's stored again in its parent
+		return set(root, tokens[:len(tokens)-1], container)
+	default:
+		return nil, errors.New("path does not point into an object or array")
+	}
+}
+
+// splits a json pointer (RFC 6901) into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must be empty or start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// index of an array element, "-" is the index past the last element which only adding may use
+func arrayIndex(token string, length int, adding bool) (int, error) {
+	if token == "-" && adding {
+		return length, nil
+	}
+
+	index, err := strconv.Atoi(token)
+
+	//leading zeros aren't allowed by the pointer syntax
+	if err != nil || index < 0 || strconv.Itoa(index) != token {
+		return 0, fmt.Errorf("%q is not an array index", token)
+	}
+
+	if index > length || index == length && !adding {
+		return 0, fmt.Errorf("index %d is out of range", index)
+	}
+
+	return index, nil
+}
+
+func get(node any, tokens []string) (any, error) {
+	for _, token := range tokens {
+		switch container := node.(type) {
+		case map[string]any:
+			child, ok := container[token]
+
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+
+			node = child
+		case []any:
+			index, err := arrayIndex(token, len(container), false)
+
+			if err != nil {
+				return nil, err
+			}
+
+			node = container[index]
+		default:
+			return nil, fmt.Errorf("%q can't be looked up in a scalar value", token)
+		}
+	}
+
+	return node, nil
+}
+
+// replaces the value the tokens point to, returning the new root
+func set(root any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	parent, err := get(root, tokens[:len(tokens)-1])
+
+	if err != nil {
+		return nil, err
+	}
+
+	key := tokens[len(tokens)-1]
+
+	switch container := parent.(type) {
+	case map[string]any:
+		container[key] = value
+	case []any:
+		index, err := arrayIndex(key, len(container), false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		container[index] = value
+	}
+
+	return root, nil
+}
+
+// compares json values, numbers by their value so 1 and 1.0 are equal
+func equal(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+
+		if !ok || len(a) != len(b) {
+			return false
+		}
+
+		for key, value := range a {
+			other, ok := b[key]
+
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+
+		return true
+	case []any:
+		b, ok := b.([]any)
+
+		if !ok || len(a) != len(b) {
+			return false
+		}
+
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+
+		if !ok {
+			return false
+		}
+
+		x, errA := a.Float64()
+		y, errB := b.Float64()
+
+		return errA == nil && errB == nil && x == y
+	default:
+		return a == b
+	}
+}
+
+// decodes json keeping numbers as written, so large integers survive the round trip
+// anything but whitespace after the value is an error
+func decode(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var value any
+
+	err := dec.Decode(&value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = dec.Decode(&struct{}{})
+
+	if !errors.Is(err, io.EOF) {
+		return nil, errors.New("must contain a single json value")
+	}
+
+	return value, nil
+}
@@ -0,0 +1,207 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// rewrites json with sorted keys and no insignificant whitespace, so documents can be compared as strings
+func normalise(t *testing.T, doc string) string {
+	t.Helper()
+
+	value, err := decode([]byte(doc))
+
+	if err != nil {
+		t.Fatalf("invalid json %s: %v", doc, err)
+	}
+
+	js, err := json.Marshal(value)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(js)
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string // empty when the patch must fail
+		err   string // part of the error message
+	}{
+		{"add member", `{"a":1}`, `[{"op":"add","path":"/b","value":2}]`, `{"a":1,"b":2}`, ""},
+		{"add replaces member", `{"a":1}`, `[{"op":"add","path":"/a","value":[1]}]`, `{"a":[1]}`, ""},
+		{"add to array end", `{"a":[1,2]}`, `[{"op":"add","path":"/a/-","value":3}]`, `{"a":[1,2,3]}`, ""},
+		{"add at array index", `{"a":[1,2]}`, `[{"op":"add","path":"/a/1","value":9}]`, `{"a":[1,9,2]}`, ""},
+		{"add at array start", `{"a":[1,2]}`, `[{"op":"add","path":"/a/0","value":9}]`, `{"a":[9,1,2]}`, ""},
+		{"add at array length", `{"a":[1,2]}`, `[{"op":"add","path":"/a/2","value":9}]`, `{"a":[1,2,9]}`, ""},
+		{"add into nested array", `[[1],[2]]`, `[{"op":"add","path":"/1/-","value":3}]`, `[[1],[2,3]]`, ""},
+		{"add past array length", `{"a":[1,2]}`, `[{"op":"add","path":"/a/3","value":9}]`, "", "index 3 is out of range"},
+		{"add with leading zero", `{"a":[1,2]}`, `[{"op":"add","path":"/a/01","value":9}]`, "", `"01" is not an array index`},
+		{"add to missing parent", `{}`, `[{"op":"add","path":"/a/b","value":1}]`, "", `member "a" does not exist`},
+		{"add whole document", `{"a":1}`, `[{"op":"add","path":"","value":[1]}]`, `[1]`, ""},
+		{"remove member", `{"a":1,"b":2}`, `[{"op":"remove","path":"/a"}]`, `{"b":2}`, ""},
+		{"remove array element", `{"a":[1,2,3]}`, `[{"op":"remove","path":"/a/1"}]`, `{"a":[1,3]}`, ""},
+		{"remove out of range", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/2"}]`, "", "index 2 is out of range"},
+		{"remove array end", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/-"}]`, "", `"-" is not an array index`},
+		{"remove missing member", `{"a":1}`, `[{"op":"remove","path":"/b"}]`, "", `member "b" does not exist`},
+		{"remove whole document", `{"a":1}`, `[{"op":"remove","path":""}]`, "", "the whole document can't be removed"},
+		{"replace member", `{"a":1}`, `[{"op":"replace","path":"/a","value":"x"}]`, `{"a":"x"}`, ""},
+		{"replace array element", `{"a":[1,2]}`, `[{"op":"replace","path":"/a/1","value":3}]`, `{"a":[1,3]}`, ""},
+		{"replace out of range", `{"a":[1,2]}`, `[{"op":"replace","path":"/a/2","value":3}]`, "", "index 2 is out of range"},
+		{"replace negative index", `{"a":[1,2]}`, `[{"op":"replace","path":"/a/-1","value":3}]`, "", `"-1" is not an array index`},
+		{"replace missing member", `{"a":1}`, `[{"op":"replace","path":"/b","value":3}]`, "", `member "b" does not exist`},
+		{"replace without value", `{"a":1}`, `[{"op":"replace","path":"/a"}]`, "", "value must be provided"},
+		{"replace with null", `{"a":1}`, `[{"op":"replace","path":"/a","value":null}]`, `{"a":null}`, ""},
+		{"tilde escape", `{"a~b":1}`, `[{"op":"replace","path":"/a~0b","value":2}]`, `{"a~b":2}`, ""},
+		{"slash escape", `{"a/b":1}`, `[{"op":"replace","path":"/a~1b","value":2}]`, `{"a/b":2}`, ""},
+		{"escapes are decoded once", `{"~1":1}`, `[{"op":"remove","path":"/~01"}]`, `{}`, ""},
+		{"empty member name", `{"":1}`, `[{"op":"replace","path":"/","value":2}]`, `{"":2}`, ""},
+		{"path without slash", `{"a":1}`, `[{"op":"remove","path":"a"}]`, "", `path "a" must be empty or start with /`},
+		{"test equal", `{"a":"x"}`, `[{"op":"test","path":"/a","value":"x"}]`, `{"a":"x"}`, ""},
+		{"test integer and float", `{"a":1}`, `[{"op":"test","path":"/a","value":1.0}]`, `{"a":1}`, ""},
+		{"test exponent", `{"a":10}`, `[{"op":"test","path":"/a","value":1e1}]`, `{"a":10}`, ""},
+		{"test number against string", `{"a":1}`, `[{"op":"test","path":"/a","value":"1"}]`, "", "test failed"},
+		{"test different number", `{"a":1}`, `[{"op":"test","path":"/a","value":1.5}]`, "", "test failed"},
+		{"test objects ignore order", `{"a":{"x":1,"y":[1,2]}}`, `[{"op":"test","path":"/a","value":{"y":[1,2],"x":1.0}}]`, `{"a":{"x":1,"y":[1,2]}}`, ""},
+		{"test array order", `{"a":[1,2]}`, `[{"op":"test","path":"/a","value":[2,1]}]`, "", "test failed"},
+		{"test null", `{"a":null}`, `[{"op":"test","path":"/a","value":null}]`, `{"a":null}`, ""},
+		{"test missing member", `{"a":1}`, `[{"op":"test","path":"/b","value":1}]`, "", `member "b" does not exist`},
+		{"large integers survive", `{"a":9007199254740993}`, `[{"op":"add","path":"/b","value":1}]`, `{"a":9007199254740993,"b":1}`, ""},
+		{"operations apply in order", `{"a":[]}`, `[{"op":"add","path":"/a/-","value":1},{"op":"add","path":"/a/0","value":0},{"op":"test","path":"/a","value":[0,1]}]`, `{"a":[0,1]}`, ""},
+		{"move is not supported", `{"a":1}`, `[{"op":"move","from":"/a","path":"/b"}]`, "", `operation "move" is not supported`},
+		{"unknown operation", `{"a":1}`, `[{"op":"bump","path":"/a"}]`, "", `unknown operation "bump"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []Operation
+
+			err := json.Unmarshal([]byte(tt.patch), &ops)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			doc := []byte(tt.doc)
+
+			got, err := Apply(doc, ops)
+
+			if string(doc) != tt.doc {
+				t.Errorf("document changed to %s", doc)
+			}
+
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("got %s, want error containing %q", got, tt.err)
+				}
+
+				if !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error %q, want it to contain %q", err, tt.err)
+				}
+
+				if got != nil {
+					t.Errorf("got %s along with the error", got)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if string(got) != normalise(t, tt.want) {
+				t.Errorf("got %s, want %s", got, normalise(t, tt.want))
+			}
+		})
+	}
+}
+
+func TestApplyTestFailure(t *testing.T) {
+	ops := []Operation{
+		{Op: "replace", Path: "/a", Value: json.RawMessage(`2`)},
+		{Op: "test", Path: "/a", Value: json.RawMessage(`1`)},
+	}
+
+	_, err := Apply([]byte(`{"a":1}`), ops)
+
+	if !errors.Is(err, ErrTestFailed) {
+		t.Fatalf("error %v, want ErrTestFailed", err)
+	}
+
+	if want := "operation 1 (test /a): test failed"; err.Error() != want {
+		t.Errorf("error %q, want %q", err, want)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"replace member", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"add member", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"null removes member", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"null for missing member", `{"a":"b"}`, `{"c":null}`, `{"a":"b"}`},
+		{"nested null removes nested member", `{"a":{"b":1,"c":2}}`, `{"a":{"b":null}}`, `{"a":{"c":2}}`},
+		{"nested null creates empty object", `{}`, `{"a":{"b":null}}`, `{"a":{}}`},
+		{"array replaces array", `{"a":[1,2]}`, `{"a":[3]}`, `{"a":[3]}`},
+		{"nulls inside arrays are kept", `{"a":1}`, `{"a":[null]}`, `{"a":[null]}`},
+		{"object replaces scalar", `{"a":1}`, `{"a":{"b":2}}`, `{"a":{"b":2}}`},
+		{"scalar replaces object", `{"a":{"b":2}}`, `{"a":1}`, `{"a":1}`},
+		{"patch array replaces document", `{"a":1}`, `["x"]`, `["x"]`},
+		{"patch null replaces document", `{"a":1}`, `null`, `null`},
+		{"object patch on array document", `[1]`, `{"a":1}`, `{"a":1}`},
+		{"empty patch", `{"a":1}`, `{}`, `{"a":1}`},
+		{"large integers survive", `{"a":9007199254740993}`, `{"b":2}`, `{"a":9007199254740993,"b":2}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if string(got) != normalise(t, tt.want) {
+				t.Errorf("got %s, want %s", got, normalise(t, tt.want))
+			}
+		})
+	}
+}
+
+func TestTrailingData(t *testing.T) {
+	for _, doc := range []string{`{"a":1} junk`, `{"a":1}{"b":2}`, `1 2`, `{"a":1}]`} {
+		if _, err := MergePatch([]byte(doc), []byte(`{}`)); err == nil {
+			t.Errorf("MergePatch accepted document %s", doc)
+		}
+
+		if _, err := MergePatch([]byte(`{}`), []byte(doc)); err == nil {
+			t.Errorf("MergePatch accepted patch %s", doc)
+		}
+
+		if _, err := Apply([]byte(doc), nil); err == nil {
+			t.Errorf("Apply accepted document %s", doc)
+		}
+
+		if _, err := Apply([]byte(`{}`), []Operation{{Op: "add", Path: "/a", Value: json.RawMessage(doc)}}); err == nil {
+			t.Errorf("Apply accepted value %s", doc)
+		}
+	}
+
+	//whitespace around the value is fine
+	got, err := MergePatch([]byte(" {\"a\":1}\n"), []byte("\t{}\n\n"))
+
+	if err != nil || string(got) != `{"a":1}` {
+		t.Errorf("got %s, %v", got, err)
+	}
+}
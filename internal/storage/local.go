@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stores files in a directory on the local filesystem, served by the api itself
+type Local struct {
+	dir     string
+	baseURL string
+}
+
+func NewLocal(dir, baseURL string) (*Local, error) {
+	err := os.MkdirAll(dir, 0o755)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+// writes to a temporary file first, so readers never see a partially written file
+func (l *Local) Put(ctx context.Context, key, contentType string, data []byte) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	path := filepath.Join(l.dir, filepath.FromSlash(key))
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), 0o644)
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	err := os.Remove(filepath.Join(l.dir, filepath.FromSlash(key)))
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func (l *Local) URL(key string) string {
+	return l.baseURL + "/" + key
+}
+
+// serves the stored files, request paths are keys
+// directories aren't listed
+func (l *Local) Handler() http.Handler {
+	return http.FileServer(filesOnly{http.Dir(l.dir)})
+}
+
+type filesOnly struct {
+	fs http.FileSystem
+}
+
+func (f filesOnly) Open(name string) (http.File, error) {
+	file, err := f.fs.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if info.IsDir() {
+		file.Close()
+		return nil, fs.ErrNotExist
+	}
+
+	return file, nil
+}
@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// stores files in a bucket of an s3 compatible service, requests are signed with aws signature version 4
+// buckets are addressed path style (endpoint/bucket/key), which every s3 compatible service supports,
+// so a local stand-in such as minio works as well as aws itself
+type S3 struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	baseURL   string
+	client    *http.Client
+}
+
+// baseURL is where the bucket is publicly readable, it defaults to the bucket url on the endpoint
+func NewS3(endpoint, region, bucket, accessKey, secretKey, baseURL string) (*S3, error) {
+	u, err := url.Parse(endpoint)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("s3 endpoint must be an absolute http(s) url")
+	}
+
+	if bucket == "" {
+		return nil, fmt.Errorf("s3 bucket must be provided")
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	if baseURL == "" {
+		baseURL = u.String() + "/" + bucket
+	}
+
+	return &S3{
+		endpoint:  u,
+		region:    region,
+		bucket:    bucket,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key, contentType string, data []byte) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	req, err := s.request(ctx, http.MethodPut, key, data)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	return s.do(req, http.StatusOK)
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	req, err := s.request(ctx, http.MethodDelete, key, nil)
+
+	if err != nil {
+		return err
+	}
+
+	//s3 answers 204 whether or not the object existed, some compatible services answer 404 instead
+	return s.do(req, http.StatusNoContent, http.StatusOK, http.StatusNotFound)
+}
+
+func (s *S3) URL(key string) string {
+	return s.baseURL + "/" + escapePath(key)
+}
+
+// builds a signed request for the object
+func (s *S3) request(ctx context.Context, method, key string, body []byte) (*http.Request, error) {
+	u := *s.endpoint
+	u.Path = s.endpoint.Path + "/" + s.bucket + "/" + key
+	u.RawPath = escapePath(u.Path)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.ContentLength = int64(len(body))
+
+	s.sign(req, body, time.Now().UTC())
+
+	return req, nil
+}
+
+func (s *S3) do(req *http.Request, expected ...int) error {
+	res, err := s.client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	for _, status := range expected {
+		if res.StatusCode == status {
+			return nil
+		}
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+
+	return fmt.Errorf("s3 %s %s: %s: %s", req.Method, req.URL.Path, res.Status, bytes.TrimSpace(message))
+}
+
+// adds the aws signature version 4 headers to the request
+func (s *S3) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	canonicalRequest, signedHeaders := canonicalRequest(req, payloadHash)
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+// returns the canonical form of the request which is signed and the list of signed headers
+// the host and every header set on the request so far are signed, headers added later such as Content-Type aren't
+func canonicalRequest(req *http.Request, payloadHash string) (string, string) {
+	headers := map[string]string{"host": req.URL.Host}
+
+	for name, values := range req.Header {
+		trimmed := make([]string, len(values))
+
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	var canonicalHeaders strings.Builder
+
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	return strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"", // no query string
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n"), signedHeaders
+}
+
+// percent encodes everything but unreserved characters and slashes, as aws expects
+func escapePath(path string) string {
+	var b strings.Builder
+
+	for _, c := range []byte(path) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', strings.IndexByte("-._~/", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
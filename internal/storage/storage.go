@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid storage key")
+)
+
+// stores uploaded files under slash separated keys, such as "posters/42/1f2e3d/small.jpg"
+type Storage interface {
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	// deleting a key which doesn't exist is not an error
+	Delete(ctx context.Context, key string) error
+	// public url the file can be fetched from
+	URL(key string) string
+}
+
+// keys must be relative paths without empty, "." or ".." segments, so they can't escape the storage root
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return false
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.Contains(segment, "\\") {
+			return false
+		}
+	}
+
+	return true
+}
@@ -3,6 +3,9 @@ package validator
 import (
 	"regexp"
 	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 var (
@@ -50,3 +53,32 @@ func Unique[T comparable](values []T) bool {
 
 	return len(values) == len(uniqueValues)
 }
+
+// reports whether value contains anything other than whitespace
+func NotBlank(value string) bool {
+	return strings.TrimSpace(value) != ""
+}
+
+// counts characters rather than bytes, so multi-byte text isn't penalised
+func MinChars(value string, n int) bool {
+	return utf8.RuneCountInString(value) >= n
+}
+
+func MaxChars(value string, n int) bool {
+	return utf8.RuneCountInString(value) <= n
+}
+
+// reports whether value is valid utf-8 free of control characters, apart from line breaks and tabs
+func PrintableText(value string) bool {
+	if !utf8.ValidString(value) {
+		return false
+	}
+
+	for _, r := range value {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+
+	return true
+}
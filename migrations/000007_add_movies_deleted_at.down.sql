@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'movies:purge';
+
+DROP INDEX IF EXISTS movies_deleted_at_idx;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS deleted_at;
\ No newline at end of file
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint REFERENCES users ON DELETE SET NULL, -- keep the history when the editor is removed
+    title text NOT NULL,
+    year integer NOT NULL,
+    runtime integer NOT NULL,
+    genres text[] NOT NULL,
+    PRIMARY KEY (movie_id, version)
+);
+
+-- existing movies start their history at the current version
+INSERT INTO movie_revisions(movie_id, version, created_at, title, year, runtime, genres)
+SELECT id, version, created_at, title, year, runtime, genres FROM movies
+ON CONFLICT DO NOTHING;
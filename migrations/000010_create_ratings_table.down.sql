@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS movies_rating_idx;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS rating;
+ALTER TABLE movies DROP COLUMN IF EXISTS rating_count;
+ALTER TABLE movies DROP COLUMN IF EXISTS rating_sum;
+
+DROP TABLE IF EXISTS ratings;
\ No newline at end of file
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS ratings (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    rating smallint NOT NULL CHECK (rating BETWEEN 1 AND 10),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS ratings_movie_id_idx ON ratings(movie_id);
+
+-- aggregates are maintained on every rating change so listing never has to scan ratings
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_sum bigint NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_count bigint NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating numeric(4, 2) GENERATED ALWAYS AS (
+    CASE WHEN rating_count > 0 THEN round(rating_sum::numeric / rating_count, 2) ELSE 0 END
+) STORED;
+
+CREATE INDEX IF NOT EXISTS movies_rating_idx ON movies(rating);
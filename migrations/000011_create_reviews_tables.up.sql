@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    title text NOT NULL,
+    body text NOT NULL,
+    hidden bool NOT NULL DEFAULT false,
+    hidden_reason text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT reviews_movie_user_key UNIQUE (movie_id, user_id) -- one review per user and movie
+);
+
+CREATE TABLE IF NOT EXISTS review_reports (
+    review_id bigint NOT NULL REFERENCES reviews ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    reason text NOT NULL,
+    PRIMARY KEY (review_id, user_id)
+);
+
+INSERT INTO
+    permissions(code)
+VALUES
+    ('reviews:moderate');
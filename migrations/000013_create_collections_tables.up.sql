@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS collections (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    title text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    public bool NOT NULL DEFAULT false,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS collections_user_id_idx ON collections(user_id);
+
+CREATE TABLE IF NOT EXISTS collection_entries (
+    collection_id bigint NOT NULL REFERENCES collections ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    position integer NOT NULL,
+    note text NOT NULL DEFAULT '',
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (collection_id, movie_id)
+);
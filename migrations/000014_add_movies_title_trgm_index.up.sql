@@ -0,0 +1,4 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- used to find movies with similar titles
+CREATE INDEX IF NOT EXISTS movies_title_trgm_idx ON movies USING GIN(title gin_trgm_ops);
@@ -0,0 +1,7 @@
+DELETE FROM permissions WHERE code = 'recommendations:manage';
+
+DROP TABLE IF EXISTS recommendation_runs;
+
+DROP TABLE IF EXISTS recommendations;
+
+DROP TABLE IF EXISTS movie_similarities;
\ No newline at end of file
@@ -0,0 +1,33 @@
+-- item to item similarities and per user recommendations, both rebuilt by the recommendations job
+CREATE TABLE IF NOT EXISTS movie_similarities (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    similar_movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    score double precision NOT NULL,
+    PRIMARY KEY (movie_id, similar_movie_id)
+);
+
+CREATE TABLE IF NOT EXISTS recommendations (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    score double precision NOT NULL,
+    computed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, movie_id)
+);
+
+CREATE TABLE IF NOT EXISTS recommendation_runs (
+    id bigserial PRIMARY KEY,
+    trigger text NOT NULL,
+    status text NOT NULL DEFAULT 'running',
+    started_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    finished_at timestamp(0) with time zone,
+    users bigint NOT NULL DEFAULT 0,
+    error text NOT NULL DEFAULT ''
+);
+
+-- at most one run at a time
+CREATE UNIQUE INDEX IF NOT EXISTS recommendation_runs_running_idx ON recommendation_runs(status) WHERE status = 'running';
+
+INSERT INTO
+    permissions(code)
+VALUES
+    ('recommendations:manage');
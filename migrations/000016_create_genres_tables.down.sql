@@ -0,0 +1,5 @@
+DROP FUNCTION IF EXISTS canonical_genre(text);
+
+DROP TABLE IF EXISTS genre_aliases;
+
+DROP TABLE IF EXISTS genres;
\ No newline at end of file
@@ -0,0 +1,76 @@
+CREATE TABLE IF NOT EXISTS genres (
+    slug text PRIMARY KEY,
+    name text NOT NULL UNIQUE
+);
+
+-- alternative spellings which are stored as the genre they point to
+CREATE TABLE IF NOT EXISTS genre_aliases (
+    alias text PRIMARY KEY,
+    genre_slug text NOT NULL REFERENCES genres ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+INSERT INTO genres(slug, name) VALUES
+    ('action', 'Action'),
+    ('adventure', 'Adventure'),
+    ('animation', 'Animation'),
+    ('biography', 'Biography'),
+    ('comedy', 'Comedy'),
+    ('crime', 'Crime'),
+    ('documentary', 'Documentary'),
+    ('drama', 'Drama'),
+    ('family', 'Family'),
+    ('fantasy', 'Fantasy'),
+    ('history', 'History'),
+    ('horror', 'Horror'),
+    ('music', 'Music'),
+    ('musical', 'Musical'),
+    ('mystery', 'Mystery'),
+    ('romance', 'Romance'),
+    ('science-fiction', 'Science Fiction'),
+    ('sport', 'Sport'),
+    ('thriller', 'Thriller'),
+    ('war', 'War'),
+    ('western', 'Western')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO genre_aliases(alias, genre_slug) VALUES
+    ('sci-fi', 'science-fiction'),
+    ('scifi', 'science-fiction'),
+    ('sf', 'science-fiction'),
+    ('animated', 'animation'),
+    ('biopic', 'biography'),
+    ('historical', 'history'),
+    ('romantic', 'romance'),
+    ('sports', 'sport')
+ON CONFLICT DO NOTHING;
+
+-- lower cases the genre and joins its words with hyphens, then resolves aliases
+-- must be kept in line with genreKey in internal/data/genres.go
+CREATE OR REPLACE FUNCTION canonical_genre(genre text) RETURNS text AS $$
+    SELECT COALESCE((SELECT genre_slug FROM genre_aliases WHERE alias = normalised.key), normalised.key)
+    FROM (SELECT regexp_replace(lower(trim(genre)), '[\s_]+', '-', 'g') AS key) AS normalised
+$$ LANGUAGE sql STABLE;
+
+-- genres already in use which don't match the taxonomy become genres of their own, so existing movies stay valid
+INSERT INTO genres(slug, name)
+SELECT DISTINCT ON (canonical_genre(genre)) canonical_genre(genre), initcap(trim(genre))
+FROM (
+    SELECT unnest(genres) AS genre FROM movies
+    UNION SELECT unnest(genres) FROM movie_revisions
+) AS used
+WHERE canonical_genre(genre) <> ''
+ORDER BY canonical_genre(genre), genre
+ON CONFLICT DO NOTHING;
+
+UPDATE movies SET genres = ARRAY(
+    SELECT slug FROM (
+        SELECT canonical_genre(used.genre) AS slug, min(used.position) AS position
+        FROM unnest(movies.genres) WITH ORDINALITY AS used(genre, position)
+        GROUP BY 1
+    ) AS normalised
+    WHERE slug <> ''
+    ORDER BY position
+)
+-- only movies with genres which aren't canonical or are repeated
+WHERE EXISTS (SELECT 1 FROM unnest(genres) AS genre WHERE genre <> canonical_genre(genre))
+OR cardinality(genres) <> (SELECT count(DISTINCT genre) FROM unnest(genres) AS genre);
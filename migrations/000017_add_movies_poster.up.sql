@@ -0,0 +1,3 @@
+-- urls of the poster renditions by size, and the storage keys they were written to
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster jsonb;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster_keys text[] NOT NULL DEFAULT '{}';
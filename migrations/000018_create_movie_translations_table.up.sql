@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS movie_translations (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    locale text NOT NULL,
+    title text NOT NULL,
+    synopsis text NOT NULL DEFAULT '',
+    search_config regconfig NOT NULL DEFAULT 'simple', -- text search configuration of the locale's language
+    search tsvector GENERATED ALWAYS AS (to_tsvector(search_config, title)) STORED,
+    PRIMARY KEY (movie_id, locale)
+);
+
+CREATE INDEX IF NOT EXISTS movie_translations_search_idx ON movie_translations USING GIN(search);
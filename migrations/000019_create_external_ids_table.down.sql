@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS movies_normalised_title_year_idx;
+DROP FUNCTION IF EXISTS normalise_title(text);
+DROP TABLE IF EXISTS external_ids;
\ No newline at end of file
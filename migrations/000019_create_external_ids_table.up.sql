@@ -0,0 +1,17 @@
+-- identifiers of the movie in other databases, such as imdb or tmdb
+CREATE TABLE IF NOT EXISTS external_ids (
+    source text NOT NULL,
+    external_id text NOT NULL,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    PRIMARY KEY (source, external_id),
+    UNIQUE (movie_id, source) -- a movie has at most one identifier per source
+);
+
+-- lower cases the title, drops punctuation and a leading article, so "The Matrix" and "Matrix, The" compare equal
+CREATE OR REPLACE FUNCTION normalise_title(title text) RETURNS text AS $$
+    SELECT trim(regexp_replace(
+        regexp_replace(trim(regexp_replace(lower(title), '[^[:alnum:]]+', ' ', 'g')), '^(the|a|an) | (the|a|an)$', '', 'g'),
+        '\s+', ' ', 'g'))
+$$ LANGUAGE SQL IMMUTABLE STRICT;
+
+CREATE INDEX IF NOT EXISTS movies_normalised_title_year_idx ON movies (normalise_title(title), year) WHERE deleted_at IS NULL;
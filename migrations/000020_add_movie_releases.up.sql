@@ -0,0 +1,17 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'released';
+ALTER TABLE movies ADD CONSTRAINT movies_status_check CHECK (status IN ('released', 'upcoming'));
+
+-- announced movies may be dated in the future, released ones still may not
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_year_check;
+ALTER TABLE movies ADD CONSTRAINT movies_year_check CHECK (year >= 1888 AND (status = 'upcoming' OR year <= date_part('year', now())));
+
+CREATE TABLE IF NOT EXISTS movie_releases (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    country text NOT NULL CHECK (country ~ '^[A-Z]{2}$'), -- ISO 3166-1 alpha-2 code
+    release_date date NOT NULL,
+    certification text NOT NULL DEFAULT '',
+    minimum_age smallint CHECK (minimum_age BETWEEN 0 AND 21), -- null when the certification doesn't imply an age
+    PRIMARY KEY (movie_id, country)
+);
+
+CREATE INDEX IF NOT EXISTS movie_releases_country_idx ON movie_releases (country, release_date);
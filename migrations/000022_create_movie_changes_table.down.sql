@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS movie_changes;
+DROP FUNCTION IF EXISTS reject_movie_change_edits;
\ No newline at end of file
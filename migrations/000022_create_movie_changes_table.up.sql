@@ -0,0 +1,25 @@
+-- append-only log of catalog changes, consumers read it in sequence order from where they left off
+-- movie_id has no foreign key so changes of purged movies stay in the log
+CREATE TABLE IF NOT EXISTS movie_changes (
+    sequence bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL,
+    operation text NOT NULL CHECK (operation IN ('create', 'update', 'delete')),
+    version integer NOT NULL,
+    movie jsonb, -- state of the movie after the change, null for deletes
+    changed_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE OR REPLACE FUNCTION reject_movie_change_edits() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'movie_changes is append-only';
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movie_changes_append_only BEFORE UPDATE OR DELETE ON movie_changes
+FOR EACH STATEMENT EXECUTE FUNCTION reject_movie_change_edits();
+
+-- existing movies start the log as created
+INSERT INTO movie_changes(movie_id, operation, version, movie, changed_at)
+SELECT id, 'create', version, jsonb_build_object('id', id, 'title', title, 'year', year, 'runtime', runtime, 'genres', genres, 'status', status, 'version', version), created_at
+FROM movies WHERE deleted_at IS NULL
+ORDER BY id;
@@ -0,0 +1,47 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    id bigserial PRIMARY KEY,
+    url text NOT NULL,
+    events text[] NOT NULL,
+    secret text NOT NULL, -- key of the HMAC-SHA256 signature of every delivery
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- an event to deliver to a webhook, retried until it succeeds or runs out of attempts
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    webhook_id bigint NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+    event text NOT NULL,
+    payload jsonb NOT NULL,
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries(webhook_id, id);
+
+-- log of every attempt of a delivery
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+    id bigserial PRIMARY KEY,
+    delivery_id bigint NOT NULL REFERENCES webhook_deliveries ON DELETE CASCADE,
+    attempted_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    duration_ms integer NOT NULL,
+    response_status integer, -- null when no response was received
+    response_body text NOT NULL DEFAULT '',
+    error text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS webhook_attempts_delivery_id_idx ON webhook_attempts(delivery_id);
+
+-- sequence of the last movie change turned into deliveries, changes recorded before webhooks existed aren't delivered
+CREATE TABLE IF NOT EXISTS webhook_dispatch (
+    last_sequence bigint NOT NULL
+);
+
+INSERT INTO webhook_dispatch(last_sequence) SELECT COALESCE(max(sequence), 0) FROM movie_changes;
+
+INSERT INTO
+    permissions(code)
+VALUES
+    ('webhooks:manage');
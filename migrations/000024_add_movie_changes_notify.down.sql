@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS movie_changes_notify ON movie_changes;
+DROP FUNCTION IF EXISTS notify_movie_changes;
\ No newline at end of file
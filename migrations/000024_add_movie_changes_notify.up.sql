@@ -0,0 +1,11 @@
+-- wakes listeners of the movie_changes channel once per transaction which recorded changes, they read the log themselves
+-- notifications are sent on commit and identical ones of a transaction are folded into one
+CREATE OR REPLACE FUNCTION notify_movie_changes() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('movie_changes', '');
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movie_changes_notify AFTER INSERT ON movie_changes
+FOR EACH STATEMENT EXECUTE FUNCTION notify_movie_changes();
@@ -0,0 +1,2 @@
+ALTER TABLE movie_revisions DROP CONSTRAINT IF EXISTS movie_revisions_status_check;
+ALTER TABLE movie_revisions DROP COLUMN IF EXISTS status;
@@ -0,0 +1,5 @@
+ALTER TABLE movie_revisions ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'released';
+ALTER TABLE movie_revisions ADD CONSTRAINT movie_revisions_status_check CHECK (status IN ('released', 'upcoming'));
+
+-- the status of earlier revisions wasn't recorded, they take the current one so it doesn't show up as changed between them
+UPDATE movie_revisions SET status = movies.status FROM movies WHERE movies.id = movie_revisions.movie_id;
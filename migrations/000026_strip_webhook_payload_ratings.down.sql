@@ -0,0 +1 @@
+-- the ratings which were removed were all 0 and wrong, there is nothing to restore
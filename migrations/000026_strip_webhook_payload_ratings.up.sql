@@ -0,0 +1,4 @@
+-- movies in queued movie events were sent with ratings of 0, which the change log never recorded
+UPDATE webhook_deliveries
+SET payload = payload #- '{data,movie,rating}' #- '{data,movie,rating_count}'
+WHERE event IN ('movie.created', 'movie.updated', 'movie.deleted') AND payload #> '{data,movie}' IS NOT NULL;
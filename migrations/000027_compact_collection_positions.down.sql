@@ -0,0 +1 @@
+-- the previous positions aren't kept, the compacted ones keep the same order
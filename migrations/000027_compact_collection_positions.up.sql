@@ -0,0 +1,9 @@
+-- entries of trashed movies kept their positions when collections were reordered, which could collide with the new ones
+UPDATE collection_entries SET position = renumbered.position
+FROM (
+    SELECT collection_id, movie_id, row_number() OVER (PARTITION BY collection_id ORDER BY position, added_at, movie_id) AS position
+    FROM collection_entries
+) AS renumbered
+WHERE collection_entries.collection_id = renumbered.collection_id
+AND collection_entries.movie_id = renumbered.movie_id
+AND collection_entries.position <> renumbered.position;
@@ -0,0 +1,4 @@
+CREATE OR REPLACE FUNCTION canonical_genre(genre text) RETURNS text AS $$
+    SELECT COALESCE((SELECT genre_slug FROM genre_aliases WHERE alias = normalised.key), normalised.key)
+    FROM (SELECT regexp_replace(lower(trim(genre)), '[\s_]+', '-', 'g') AS key) AS normalised
+$$ LANGUAGE sql STABLE;
@@ -0,0 +1,29 @@
+-- trim() only removed spaces, so leading and trailing underscores and other whitespace became hyphens
+-- the genre is now split on runs of whitespace and underscores the way genreKey in internal/data/genres.go does
+CREATE OR REPLACE FUNCTION canonical_genre(genre text) RETURNS text AS $$
+    SELECT COALESCE((SELECT genre_slug FROM genre_aliases WHERE alias = normalised.key), normalised.key)
+    FROM (SELECT regexp_replace(regexp_replace(lower(genre), '^[\s_]+|[\s_]+$', '', 'g'), '[\s_]+', '-', 'g') AS key) AS normalised
+$$ LANGUAGE sql STABLE;
+
+-- genres normalised by the previous function, e.g. "-action-", are normalised again
+INSERT INTO genres(slug, name)
+SELECT DISTINCT ON (canonical_genre(genre)) canonical_genre(genre), initcap(replace(canonical_genre(genre), '-', ' '))
+FROM (SELECT unnest(genres) AS genre FROM movies) AS used
+WHERE canonical_genre(genre) <> '' AND genre <> canonical_genre(genre)
+ORDER BY canonical_genre(genre), genre
+ON CONFLICT DO NOTHING;
+
+UPDATE movies SET genres = ARRAY(
+    SELECT slug FROM (
+        SELECT canonical_genre(used.genre) AS slug, min(used.position) AS position
+        FROM unnest(movies.genres) WITH ORDINALITY AS used(genre, position)
+        GROUP BY 1
+    ) AS normalised
+    WHERE slug <> ''
+    ORDER BY position
+)
+WHERE EXISTS (SELECT 1 FROM unnest(genres) AS genre WHERE genre <> canonical_genre(genre))
+OR cardinality(genres) <> (SELECT count(DISTINCT genre) FROM unnest(genres) AS genre);
+
+DELETE FROM genres
+WHERE slug <> canonical_genre(slug) AND NOT EXISTS (SELECT 1 FROM movies WHERE genres.slug = ANY(movies.genres));